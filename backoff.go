@@ -0,0 +1,107 @@
+package rmq
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+)
+
+type (
+	// BackoffPolicy - strategy of pauses between retries
+	BackoffPolicy interface {
+		// Delay - returns pause before the next attempt, attempt is a count of failed attempts (starts from 1).
+		// ok == false means that attempts limit is reached
+		Delay(attempt int) (delay time.Duration, ok bool)
+	}
+
+	// ConstantBackoff - same pause between every attempt
+	ConstantBackoff struct {
+		// Interval - pause between attempts
+		Interval time.Duration
+		// MaxAttempts - attempts limit, 0 means unlimited
+		MaxAttempts int
+	}
+
+	// ExponentialBackoff - growing pause between attempts with optional jitter and cap
+	ExponentialBackoff struct {
+		// InitialInterval - pause after the first failed attempt, default is 100ms
+		InitialInterval time.Duration
+		// MaxInterval - cap for a single pause, 0 means no cap
+		MaxInterval time.Duration
+		// Multiplier - pause growth factor, default is 2
+		Multiplier float64
+		// Jitter - randomization factor in [0, 1], pause will be in [delay - delay*Jitter, delay + delay*Jitter]
+		Jitter float64
+		// MaxAttempts - attempts limit, 0 means unlimited
+		MaxAttempts int
+	}
+)
+
+// Delay - see BackoffPolicy
+func (cb *ConstantBackoff) Delay(attempt int) (time.Duration, bool) {
+	if cb.MaxAttempts > 0 && attempt >= cb.MaxAttempts {
+		return 0, false
+	}
+
+	return cb.Interval, true
+}
+
+// Delay - see BackoffPolicy
+func (eb *ExponentialBackoff) Delay(attempt int) (time.Duration, bool) {
+	if eb.MaxAttempts > 0 && attempt >= eb.MaxAttempts {
+		return 0, false
+	}
+
+	initial := eb.InitialInterval
+	if initial == 0 {
+		initial = time.Millisecond * 100
+	}
+
+	multiplier := eb.Multiplier
+	if multiplier == 0 {
+		multiplier = 2
+	}
+
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	delay := float64(initial) * math.Pow(multiplier, float64(attempt-1))
+	// protection from overflow on big attempts count: Inf with jitter gives NaN
+	if math.IsInf(delay, 1) || delay > math.MaxInt64 {
+		delay = math.MaxInt64
+	}
+
+	if eb.Jitter > 0 {
+		delay += delay * eb.Jitter * (2*rand.Float64() - 1)
+	}
+
+	if eb.MaxInterval > 0 && delay > float64(eb.MaxInterval) {
+		delay = float64(eb.MaxInterval)
+	}
+
+	// protection from overflow on big attempts count without cap
+	if delay >= math.MaxInt64 {
+		return math.MaxInt64, true
+	}
+
+	return time.Duration(delay), true
+}
+
+// sleepCtx - sleeps given duration, returns ctx error if ctx was done earlier
+func sleepCtx(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
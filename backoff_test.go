@@ -0,0 +1,136 @@
+package rmq
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestConstantBackoff_Delay(t *testing.T) {
+	tests := []struct {
+		name      string
+		backoff   ConstantBackoff
+		attempt   int
+		wantDelay time.Duration
+		wantOk    bool
+	}{
+		{
+			"Unlimited attempts",
+			ConstantBackoff{Interval: time.Second},
+			100,
+			time.Second,
+			true,
+		},
+		{
+			"Attempt below limit",
+			ConstantBackoff{Interval: time.Second, MaxAttempts: 3},
+			2,
+			time.Second,
+			true,
+		},
+		{
+			"Limit reached",
+			ConstantBackoff{Interval: time.Second, MaxAttempts: 3},
+			3,
+			0,
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, ok := tt.backoff.Delay(tt.attempt)
+			if delay != tt.wantDelay || ok != tt.wantOk {
+				t.Errorf("Delay() = (%v, %v), want (%v, %v)", delay, ok, tt.wantDelay, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestExponentialBackoff_Delay(t *testing.T) {
+	tests := []struct {
+		name      string
+		backoff   ExponentialBackoff
+		attempt   int
+		wantDelay time.Duration
+		wantOk    bool
+	}{
+		{
+			"Defaults, first attempt",
+			ExponentialBackoff{},
+			1,
+			time.Millisecond * 100,
+			true,
+		},
+		{
+			"Defaults, fourth attempt",
+			ExponentialBackoff{},
+			4,
+			time.Millisecond * 800,
+			true,
+		},
+		{
+			"Custom multiplier",
+			ExponentialBackoff{InitialInterval: time.Second, Multiplier: 3},
+			3,
+			time.Second * 9,
+			true,
+		},
+		{
+			"Capped",
+			ExponentialBackoff{InitialInterval: time.Second, MaxInterval: time.Second * 5},
+			10,
+			time.Second * 5,
+			true,
+		},
+		{
+			"Huge attempt without cap",
+			ExponentialBackoff{},
+			10000,
+			time.Duration(1<<63 - 1),
+			true,
+		},
+		{
+			"Huge attempt with jitter and cap",
+			ExponentialBackoff{MaxInterval: time.Second * 30, Jitter: 0.2},
+			10000,
+			time.Second * 30,
+			true,
+		},
+		{
+			"Limit reached",
+			ExponentialBackoff{MaxAttempts: 5},
+			5,
+			0,
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, ok := tt.backoff.Delay(tt.attempt)
+			if delay != tt.wantDelay || ok != tt.wantOk {
+				t.Errorf("Delay() = (%v, %v), want (%v, %v)", delay, ok, tt.wantDelay, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestExponentialBackoff_DelayJitter(t *testing.T) {
+	backoff := ExponentialBackoff{InitialInterval: time.Second, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		delay, ok := backoff.Delay(2)
+		if !ok {
+			t.Fatalf("unexpected attempts limit")
+		}
+
+		if delay < time.Second || delay > time.Second*3 {
+			t.Errorf("delay %s out of jitter range", delay)
+		}
+	}
+
+	backoff = ExponentialBackoff{Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		if delay, _ := backoff.Delay(10000); delay < time.Duration(math.MaxInt64/2) {
+			t.Fatalf("delay %s of huge attempt is too small", delay)
+		}
+	}
+}
@@ -1,14 +1,22 @@
 package rmq
 
 import (
+	amqp "github.com/rabbitmq/amqp091-go"
 	"time"
 )
 
 type (
-	// ConnectionCfg - main connection config, is not used, because reconnect doesnt supported
+	// ConnectionCfg - main connection config
 	ConnectionCfg struct {
 		// ReconnectTimeout - period, when process try to establish connection again.
+		// Default is 5 seconds, used only if Backoff is not set
 		ReconnectTimeout time.Duration
+		// Backoff - pauses and attempts limit for Connect and reconnect after broker-initiated close.
+		// Default is ConstantBackoff with ReconnectTimeout interval and unlimited attempts
+		Backoff BackoffPolicy
+		// RecoverTopology - record successful Schema operations (Declare, Bind, ApplyPresets, etc.)
+		// and replay them in order after reconnect
+		RecoverTopology bool
 	}
 
 	// ConsumerConfig - main consumer config
@@ -17,6 +25,32 @@ type (
 		WorkersCount int
 		// run message handling in a single goroutine or in worker loop
 		Synchronous bool
+		// MaxInFlight - max count of concurrently handled messages per worker in asynchronous mode, 0 means no limit.
+		// Set Qos.PrefetchCount not less than this value to keep all slots busy
+		MaxInFlight int
+		// WorkerRestart - pauses between worker restarts after channel errors or connection recovery.
+		// MaxAttempts of policy limits consecutive failed attempts to start consuming. Nil disables restarts
+		WorkerRestart BackoffPolicy
+		// Qos - prefetch settings, applied on every worker channel (including restarts). Nil means no limits
+		Qos *QosParams
+		// OnCancel - hook, called when broker cancels worker consumer tag (queue deleted, HA failover, etc.)
+		OnCancel func(consumerTag string)
+		// Middlewares - applied to every handler of consumer, the first middleware is the outermost
+		Middlewares []Middleware
+		// PanicAction - action for message, which handler panicked, default is ActionReject
+		// (message is dead-lettered if queue has DLX)
+		PanicAction MsgAction
+		// OnPanic - hook, called after handler panic recovery, e.g. for alerting
+		OnPanic func(msg *amqp.Delivery, err *PanicError)
+		// HandlerTimeout - max duration of a single message handling, 0 means no limit
+		HandlerTimeout time.Duration
+		// DeadlineFromMessage - limits handling by message deadline: DeadlineHeader value
+		// (unix milliseconds, timestamp or RFC3339 string) or Expiration counted from message Timestamp
+		DeadlineFromMessage bool
+		// TimeoutAction - action for message, which is not acked or nacked by handler before deadline,
+		// default is ActionReject. Handler ctx is cancelled at deadline, later acks of handler are ignored.
+		// Handler is not called for messages with passed deadline. Action is skipped for AutoAck consumers
+		TimeoutAction MsgAction
 	}
 
 	// PublisherConfig - main publisher config
@@ -27,5 +61,38 @@ type (
 		CleanUpInterval time.Duration
 		// Max idle time per one channel. Set this param smaller than CleanUpInterval
 		MaxIdleTime time.Duration
+		// ConfirmMode - put pooled channels in confirm mode, Publish waits for broker ack/nack of every message
+		ConfirmMode bool
+		// ReturnHandler - handler for messages returned by broker, which can't be matched with Publish call.
+		// In confirm mode mandatory messages are matched by ReturnIDHeader, stamped on publishing, and Publish
+		// returns UnroutableError. Returns are logged if handler is not set
+		ReturnHandler func(ret amqp.Return)
+		// Retry - retry policy for failed publishes, nil disables retries
+		Retry *PublishRetryPolicy
+		// Buffer - in-memory buffer for messages, published while connection is down. Buffered messages are
+		// flushed in order after connection recovery. Nil disables buffering
+		Buffer *OutageBufferCfg
+		// Spool - durable disk spool for messages, published while connection is down or nacked by broker.
+		// Spooled messages are replayed in order after connection recovery. Has priority over Buffer
+		Spool *DiskSpool
+	}
+
+	// OutageBufferCfg - limits of Publisher outage buffer
+	OutageBufferCfg struct {
+		// MaxMessages - max buffered messages count, 0 means no limit
+		MaxMessages int
+		// MaxBytes - max summary size of buffered message bodies, 0 means no limit
+		MaxBytes int
+		// Overflow - behaviour of full buffer, OverflowBlock by default
+		Overflow OverflowPolicy
+	}
+
+	// PublishRetryPolicy - retry policy for Publisher, failed publish is retried on a fresh pooled channel
+	// or after connection recovery
+	PublishRetryPolicy struct {
+		// Backoff - pauses and attempts limit, default is ExponentialBackoff with 5 attempts
+		Backoff BackoffPolicy
+		// Retryable - checks if error should be retried, default is IsRetryablePublishErr
+		Retryable func(err error) bool
 	}
 )
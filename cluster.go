@@ -0,0 +1,133 @@
+package rmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+	"math/rand"
+	"sync"
+)
+
+// NodeSelectMode - order of cluster nodes for dialing
+type NodeSelectMode int
+
+const (
+	// NodesInOrder - nodes are tried in declared order, starting from the last good one
+	NodesInOrder NodeSelectMode = iota
+	// NodesRandom - nodes are tried in random order, starting from the last good one
+	NodesRandom
+)
+
+// clusterDialer - dials cluster nodes one by one and remembers the last good node
+type clusterDialer struct {
+	mu   sync.Mutex
+	dsns []string
+	mode NodeSelectMode
+	// dialFunc - amqp.Dial by default
+	dialFunc func(dsn string) (*amqp.Connection, error)
+	// lastGood - index of the last successfully dialed node, -1 if there is no such node
+	lastGood int
+	// connected - true if the last dial was successful, next dial means that connection was lost
+	connected bool
+}
+
+// NewClusterConnection - creates new Connection instance, which dials cluster nodes from dsns list with failover.
+// Connection moves to the next node on dial failure or disconnect, current node is available by Connection.Node
+func NewClusterConnection(ctx context.Context, dsns []string, mode NodeSelectMode, cfg *ConnectionCfg) *Connection {
+	dialer := &clusterDialer{
+		dsns:     dsns,
+		mode:     mode,
+		dialFunc: amqp.Dial,
+		lastGood: -1,
+	}
+
+	connection := NewConnectionWithCfg(ctx, dialer.dial, cfg)
+	connection.nodeFunc = dialer.node
+
+	return connection
+}
+
+// dial - tries nodes one by one, returns the first established connection
+func (cd *clusterDialer) dial() (*amqp.Connection, error) {
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+
+	if len(cd.dsns) == 0 {
+		return nil, errors.New("cluster nodes list is empty")
+	}
+
+	var lastErr error
+	for _, idx := range cd.order() {
+		conn, err := cd.dialFunc(cd.dsns[idx])
+		if err != nil {
+			logrus.WithError(err).Warningf("cannot establish connection to rmq node %s", nodeAddress(cd.dsns[idx]))
+			lastErr = err
+			continue
+		}
+
+		cd.lastGood = idx
+		cd.connected = true
+		return conn, nil
+	}
+
+	cd.connected = false
+	return nil, fmt.Errorf("all rmq cluster nodes are unreachable, last err: %w", lastErr)
+}
+
+// node - address of the last good node
+func (cd *clusterDialer) node() string {
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+
+	if cd.lastGood < 0 {
+		return ""
+	}
+
+	return nodeAddress(cd.dsns[cd.lastGood])
+}
+
+// order - nodes indexes for the next dial. The last good node goes first after dial failure
+// and goes last after disconnect
+func (cd *clusterDialer) order() []int {
+	count := len(cd.dsns)
+	order := make([]int, 0, count)
+
+	if cd.lastGood < 0 {
+		for idx := 0; idx < count; idx++ {
+			order = append(order, idx)
+		}
+
+		if cd.mode == NodesRandom {
+			rand.Shuffle(count, func(i, j int) { order[i], order[j] = order[j], order[i] })
+		}
+
+		return order
+	}
+
+	// other nodes, starting from the next after the last good one
+	for offset := 1; offset < count; offset++ {
+		order = append(order, (cd.lastGood+offset)%count)
+	}
+
+	if cd.mode == NodesRandom {
+		rand.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+	}
+
+	if cd.connected {
+		return append(order, cd.lastGood)
+	}
+
+	return append([]int{cd.lastGood}, order...)
+}
+
+// nodeAddress - host:port of the dsn without credentials, empty string for invalid dsn
+func nodeAddress(dsn string) string {
+	uri, err := amqp.ParseURI(dsn)
+	if err != nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%s:%d", uri.Host, uri.Port)
+}
@@ -2,11 +2,17 @@ package rmq
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
 )
 
+// ErrConnectionNotReady - connection is not established yet or is lost
+var ErrConnectionNotReady = errors.New("rmq connection is not ready")
+
 type (
 	// Connection - wrapped connection struct
 	Connection struct {
@@ -16,8 +22,26 @@ type (
 		doneFunc context.CancelFunc
 		// constructor - closure, which creates a new amqp.Connection and stores it params in external context
 		constructor AmqpConnectionConstructor
-		// conn - stored amqp.Connection
+		// cfg - connection config
+		cfg ConnectionCfg
+		// topology - recorded schema operations, nil if ConnectionCfg.RecoverTopology is disabled
+		topology *topologyRecorder
+		// nodeFunc - returns address of the node, which was dialed by constructor
+		nodeFunc func() string
+		// mu - guards conn, notifyClose, closing, node, ready and reconnectListeners
+		mu sync.RWMutex
+		// conn - stored amqp.Connection, replaced on every reconnect
 		conn *amqp.Connection
+		// notifyClose - close receiver of conn, registered right after dial, so no close event is lost
+		notifyClose chan *amqp.Error
+		// closing - Close was called, connection must not be reestablished
+		closing bool
+		// ready - closed when conn is established, recreated when conn is lost
+		ready chan struct{}
+		// node - address of the connected node
+		node string
+		// reconnectListeners - receivers of reconnect events, see NotifyReconnect
+		reconnectListeners []chan struct{}
 	}
 
 	// AmqpConnectionConstructor - wrap method for amqp.Connection creation (cases for amqp.DialConfig or other methods)
@@ -26,50 +50,126 @@ type (
 
 // NewDefaultConnection - creates new Connection instance with amqp.Dial method for connection inside
 func NewDefaultConnection(ctx context.Context, dsn string) *Connection {
-	return NewConnection(ctx, func() (*amqp.Connection, error) {
+	return NewDefaultConnectionWithCfg(ctx, dsn, nil)
+}
+
+// NewDefaultConnectionWithCfg - NewDefaultConnection with reconnect and topology recovery settings, cfg may be nil
+func NewDefaultConnectionWithCfg(ctx context.Context, dsn string, cfg *ConnectionCfg) *Connection {
+	connection := NewConnectionWithCfg(ctx, func() (*amqp.Connection, error) {
 		return amqp.Dial(dsn)
-	})
+	}, cfg)
+	connection.nodeFunc = func() string {
+		return nodeAddress(dsn)
+	}
+
+	return connection
 }
 
 // NewConnection - creates a new Connection
 func NewConnection(ctx context.Context, constructor AmqpConnectionConstructor) *Connection {
+	return NewConnectionWithCfg(ctx, constructor, nil)
+}
+
+// NewConnectionWithCfg - creates a new Connection with reconnect and topology recovery settings, cfg may be nil
+func NewConnectionWithCfg(ctx context.Context, constructor AmqpConnectionConstructor, cfg *ConnectionCfg) *Connection {
 	mainCtx, done := context.WithCancel(ctx)
 
-	return &Connection{
+	connection := &Connection{
 		ctx:         mainCtx,
 		doneFunc:    done,
 		constructor: constructor,
+		ready:       make(chan struct{}),
+	}
+
+	if cfg != nil {
+		connection.cfg = *cfg
+	}
+
+	if connection.cfg.ReconnectTimeout == 0 {
+		connection.cfg.ReconnectTimeout = time.Second * 5
+	}
+
+	if connection.cfg.Backoff == nil {
+		connection.cfg.Backoff = &ConstantBackoff{Interval: connection.cfg.ReconnectTimeout}
+	}
+
+	if connection.cfg.RecoverTopology {
+		connection.topology = &topologyRecorder{}
 	}
+
+	return connection
 }
 
-// Schema - creates a new schema object with new channel inside
+// Schema - creates a new schema object with new channel inside.
+// If ConnectionCfg.RecoverTopology is enabled, successful schema operations will be replayed after reconnect
 func (cn *Connection) Schema() (*Schema, error) {
 	channel, err := cn.Channel()
 	if err != nil {
 		return nil, err
 	}
 
-	return GetSchema(channel), nil
+	var recorder *schemaRecorder
+	if cn.topology != nil {
+		recorder = &schemaRecorder{topology: cn.topology}
+	}
+
+	return newSchema(channel, recorder), nil
 }
 
-// Conn - connection getter
+// Conn - connection getter, returned value may be replaced after reconnect
 func (cn *Connection) Conn() *amqp.Connection {
+	cn.mu.RLock()
+	defer cn.mu.RUnlock()
+
 	return cn.conn
 }
 
-// IsClosed - wrap for amqp.Connection IsClosed method
+// Node - address (host:port) of the connected node, empty if node is unknown (custom constructor)
+func (cn *Connection) Node() string {
+	cn.mu.RLock()
+	defer cn.mu.RUnlock()
+
+	return cn.node
+}
+
+// IsClosed - wrap for amqp.Connection IsClosed method, returns true while connection is not established
 func (cn *Connection) IsClosed() bool {
-	return cn.conn.IsClosed()
+	conn := cn.Conn()
+	if conn == nil {
+		return true
+	}
+
+	return conn.IsClosed()
 }
 
 // Channel - wrap for amqp.Connection Channel method
 func (cn *Connection) Channel() (*amqp.Channel, error) {
-	return cn.conn.Channel()
+	conn := cn.Conn()
+	if conn == nil {
+		return nil, amqp.ErrClosed
+	}
+
+	return conn.Channel()
 }
 
-// Close - connection close wrapped method
+// Close - connection close wrapped method, stops reconnect if it is in progress
 func (cn *Connection) Close() error {
-	return cn.conn.Close()
+	cn.mu.Lock()
+	cn.closing = true
+	conn := cn.conn
+	cn.mu.Unlock()
+
+	if conn == nil {
+		return amqp.ErrClosed
+	}
+
+	err := conn.Close()
+	if errors.Is(err, amqp.ErrClosed) {
+		// connection is lost already, so reconnect may be in progress
+		cn.doneFunc()
+	}
+
+	return err
 }
 
 // Connect - establish connection with rmq, context need for deadline/timeout stories
@@ -79,62 +179,206 @@ func (cn *Connection) Connect(ctx context.Context) error {
 		return err
 	}
 
-	logrus.Info("connection to rqm is established")
-	cn.conn = conn
+	if !cn.setConn(conn) {
+		return amqp.ErrClosed
+	}
+
+	cn.markReady()
+	logrus.WithField("node", cn.Node()).Info("connection to rqm is established")
 	go cn.background()
 	return nil
 }
 
+// NotifyReconnect - registers a listener for successful reconnect events.
+// Event is sent without blocking, so use buffered channel for receiver. Unregister it with StopNotifyReconnect
+func (cn *Connection) NotifyReconnect(receiver chan struct{}) chan struct{} {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+
+	cn.reconnectListeners = append(cn.reconnectListeners, receiver)
+	return receiver
+}
+
+// StopNotifyReconnect - unregisters a listener, registered by NotifyReconnect
+func (cn *Connection) StopNotifyReconnect(receiver chan struct{}) {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+
+	for num, listener := range cn.reconnectListeners {
+		if listener == receiver {
+			cn.reconnectListeners = append(cn.reconnectListeners[:num], cn.reconnectListeners[num+1:]...)
+			return
+		}
+	}
+}
+
 // background - running in single goroutine for listening Done ctx errors and amqp.Connection NotifyClose methods
 func (cn *Connection) background() {
 	ctxDoneChan := cn.ctx.Done()
-	notifyClose := cn.conn.NotifyClose(make(chan *amqp.Error, 1))
-	defer close(notifyClose)
 
 	for {
+		cn.mu.RLock()
+		conn, notifyClose := cn.conn, cn.notifyClose
+		cn.mu.RUnlock()
+
 		select {
 		case <-ctxDoneChan:
-			if !cn.conn.IsClosed() {
-				err := cn.conn.Close()
+			if !conn.IsClosed() {
+				err := conn.Close()
 				if err != nil {
 					logrus.Errorf("error while rmq conn closing: %s", err)
 				}
 			}
 
+			if cn.isClosing() {
+				logrus.Info("connection to rmq was closed")
+				return
+			}
+
 			logrus.Errorf("connection to rmq is closed, reason: %s", cn.ctx.Err())
 			return
 		case err := <-notifyClose:
-			logrus.WithError(err).Error("connection to rmq was closed")
-			cn.doneFunc()
-			return
+			if cn.isClosing() {
+				logrus.Info("connection to rmq was closed")
+				cn.doneFunc()
+				return
+			}
+
+			// nil error without Close call means the connection was closed before close receiver registration
+			logrus.WithError(err).Error("connection to rmq was lost, reconnecting")
+			if rErr := cn.reconnect(); rErr != nil {
+				if cn.isClosing() {
+					logrus.Info("connection to rmq was closed while reconnecting")
+				} else {
+					logrus.WithError(rErr).Error("unable to reconnect to rmq")
+				}
+
+				cn.doneFunc()
+				return
+			}
 		}
 	}
 }
 
-// NotifyClose - wrap for amqp.Connection NotifyClose method
-func (cn *Connection) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
-	return cn.conn.NotifyClose(receiver)
+// isClosing - Close was called
+func (cn *Connection) isClosing() bool {
+	cn.mu.RLock()
+	defer cn.mu.RUnlock()
+
+	return cn.closing
 }
 
-// connect - ctx dependent private connect method
-func (cn *Connection) connect(ctx context.Context, constructor AmqpConnectionConstructor) (*amqp.Connection, error) {
-	retry := 1
-	for {
+// reconnect - redials connection through constructor and notifies listeners
+func (cn *Connection) reconnect() error {
+	cn.mu.Lock()
+	cn.ready = make(chan struct{})
+	cn.mu.Unlock()
+
+	conn, err := cn.connect(cn.ctx, cn.constructor)
+	if err != nil {
+		return err
+	}
+
+	if !cn.setConn(conn) {
+		return amqp.ErrClosed
+	}
+
+	logrus.WithField("node", cn.Node()).Info("connection to rqm is reestablished")
+	// topology must be ready before dependents resume their work
+	cn.recoverTopology()
+	cn.markReady()
+
+	cn.mu.RLock()
+	defer cn.mu.RUnlock()
+	for _, listener := range cn.reconnectListeners {
 		select {
-		case <-ctx.Done():
-			return nil, fmt.Errorf("unable to connect to rmq: %s", ctx.Err())
+		case listener <- struct{}{}:
 		default:
-			var conn *amqp.Connection
-			var err error
-			conn, err = constructor()
-
-			if err != nil {
-				logrus.WithField("err", err).Warningf("cannot establish connection to rmq, retry %d", retry)
-				retry++
-				continue
-			}
+		}
+	}
+
+	return nil
+}
+
+// setConn - swaps stored amqp.Connection and registers its close receiver before any other round trip,
+// returns false and closes conn if Close was called meanwhile
+func (cn *Connection) setConn(conn *amqp.Connection) bool {
+	cn.mu.Lock()
+	closing := cn.closing
+	if !closing {
+		cn.conn = conn
+		cn.notifyClose = conn.NotifyClose(make(chan *amqp.Error, 1))
+		if cn.nodeFunc != nil {
+			cn.node = cn.nodeFunc()
+		}
+	}
+	cn.mu.Unlock()
 
+	if !closing {
+		return true
+	}
+
+	if err := conn.Close(); err != nil {
+		logrus.Errorf("error while rmq conn closing: %s", err)
+	}
+
+	return false
+}
+
+// markReady - unblocks waitReady callers
+func (cn *Connection) markReady() {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+
+	select {
+	case <-cn.ready:
+	default:
+		close(cn.ready)
+	}
+}
+
+// waitReady - blocks until connection is established, ctx is done or connection is stopped
+func (cn *Connection) waitReady(ctx context.Context) error {
+	cn.mu.RLock()
+	ready := cn.ready
+	cn.mu.RUnlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-cn.ctx.Done():
+		return cn.ctx.Err()
+	}
+}
+
+// NotifyClose - wrap for amqp.Connection NotifyClose method.
+// Receiver is bound to the current amqp.Connection only, use NotifyReconnect for reconnect events
+func (cn *Connection) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	return cn.Conn().NotifyClose(receiver)
+}
+
+// connect - ctx dependent private connect method, pauses between attempts are taken from cfg.Backoff
+func (cn *Connection) connect(ctx context.Context, constructor AmqpConnectionConstructor) (*amqp.Connection, error) {
+	for attempt := 1; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("unable to connect to rmq: %s", err)
+		}
+
+		conn, err := constructor()
+		if err == nil {
 			return conn, nil
 		}
+
+		delay, ok := cn.cfg.Backoff.Delay(attempt)
+		if !ok {
+			return nil, fmt.Errorf("unable to connect to rmq after %d attempts: %w", attempt, err)
+		}
+
+		logrus.WithField("err", err).Warningf("cannot establish connection to rmq, retry %d in %s", attempt, delay)
+		if err = sleepCtx(ctx, delay); err != nil {
+			return nil, fmt.Errorf("unable to connect to rmq: %s", err)
+		}
 	}
 }
@@ -0,0 +1,24 @@
+package rmq
+
+import (
+	"context"
+	"testing"
+)
+
+func TestConnection_StopNotifyReconnect(t *testing.T) {
+	connection := NewConnection(context.Background(), nil)
+	first := connection.NotifyReconnect(make(chan struct{}, 1))
+	second := connection.NotifyReconnect(make(chan struct{}, 1))
+
+	connection.StopNotifyReconnect(first)
+	if len(connection.reconnectListeners) != 1 || connection.reconnectListeners[0] != second {
+		t.Fatalf("listeners = %v, want only second listener", connection.reconnectListeners)
+	}
+
+	// unknown receiver is ignored
+	connection.StopNotifyReconnect(make(chan struct{}))
+	connection.StopNotifyReconnect(second)
+	if len(connection.reconnectListeners) != 0 {
+		t.Errorf("listeners left: %d", len(connection.reconnectListeners))
+	}
+}
@@ -7,6 +7,17 @@ import (
 	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrConsumerCancelled - consumer was cancelled by broker (queue deleted, HA failover, etc.)
+	ErrConsumerCancelled = errors.New("consumer cancelled by broker")
+	// ErrConsumerShutdown - consumer is shut down and can not start new workers
+	ErrConsumerShutdown = errors.New("consumer is shut down")
 )
 
 type (
@@ -15,6 +26,13 @@ type (
 		Queue, Consumer                     string
 		AutoAck, Exclusive, NoLocal, NoWait bool
 		Args                                amqp.Table
+		// Qos - overrides ConsumerConfig.Qos for this call
+		Qos *QosParams
+	}
+	// QosParams - wrapped amqp.Channel Qos method`s args
+	QosParams struct {
+		PrefetchCount, PrefetchSize int
+		Global                      bool
 	}
 	// Consumer - instance for consuming process
 	Consumer struct {
@@ -22,6 +40,31 @@ type (
 		cfg        ConsumerConfig
 		ctx        context.Context
 		done       context.CancelFunc
+		// mu - guards shutdown flag and workers wait group
+		mu       sync.Mutex
+		shutdown bool
+		// stopping - closed by Shutdown, workers stop consuming and drain handlers
+		stopping chan struct{}
+		// workers - running StartWorker calls
+		workers sync.WaitGroup
+		// tagsCounter - counter for generated consumer tags
+		tagsCounter uint64
+		// panics - count of recovered handler panics
+		panics uint64
+	}
+
+	// workerRestarts - counter of consecutive failed worker attempts
+	workerRestarts struct {
+		policy  BackoffPolicy
+		attempt int
+	}
+
+	// PanicError - recovered handler panic
+	PanicError struct {
+		// Value - value passed to panic
+		Value interface{}
+		// Stack - stack trace of panicked goroutine
+		Stack []byte
 	}
 )
 
@@ -33,19 +76,28 @@ func NewConsumer(connection *Connection, cfg *ConsumerConfig) *Consumer {
 		cfg:        *cfg,
 		ctx:        ctx,
 		done:       done,
+		stopping:   make(chan struct{}),
 	}
 
 	if consumer.cfg.WorkersCount == 0 {
 		consumer.cfg.WorkersCount = 1
 	}
 
+	if consumer.cfg.PanicAction == 0 {
+		consumer.cfg.PanicAction = ActionReject
+	}
+
+	if consumer.cfg.TimeoutAction == 0 {
+		consumer.cfg.TimeoutAction = ActionReject
+	}
+
 	return consumer
 }
 
 // StartWorkersGroup - start group of workers for single queue
 func (cnr *Consumer) StartWorkersGroup(params *ConsumeParams, handler MessageHandler) (err error) {
 	if cnr.connection.IsClosed() {
-		err = errors.New("rmq connection not ready")
+		err = ErrConnectionNotReady
 		return
 	}
 
@@ -72,12 +124,140 @@ func (cnr *Consumer) StartWorkersGroup(params *ConsumeParams, handler MessageHan
 	return
 }
 
-// StartWorker - starts single consumer worker on a single queue
+// StartWorker - starts single consumer worker on a single queue.
+// If ConsumerConfig.WorkerRestart is set, worker reopens channel and consumes again after channel errors
+// or connection recovery, until restart policy gives up.
+// Returns nil after Shutdown call, when all worker's handlers are finished.
+// Handler is wrapped with ConsumerConfig.Middlewares
 func (cnr *Consumer) StartWorker(ctx context.Context, params *ConsumeParams, handler MessageHandler) error {
+	cnr.mu.Lock()
+	if cnr.shutdown {
+		cnr.mu.Unlock()
+		return ErrConsumerShutdown
+	}
+	cnr.workers.Add(1)
+	cnr.mu.Unlock()
+	defer cnr.workers.Done()
+
+	workerParams := *params
+	// consumer tag is required for basic.cancel on shutdown
+	if workerParams.Consumer == "" {
+		workerParams.Consumer = fmt.Sprintf("%s-ctag-%d", workerParams.Queue, atomic.AddUint64(&cnr.tagsCounter, 1))
+	}
+	params = &workerParams
+	handler = Chain(handler, cnr.cfg.Middlewares...)
+
+	// restartCtx - ctx for pauses between restarts, which is also done on shutdown
+	restartCtx, cancelRestart := context.WithCancel(ctx)
+	defer cancelRestart()
+	go func() {
+		select {
+		case <-cnr.stopping:
+			cancelRestart()
+		case <-restartCtx.Done():
+		}
+	}()
+
+	// semaphore of async handlers, lives between restarts because old handlers may still run
+	var inFlight chan struct{}
+	if !cnr.cfg.Synchronous && cnr.cfg.MaxInFlight > 0 {
+		inFlight = make(chan struct{}, cnr.cfg.MaxInFlight)
+	}
+
+	restarts := &workerRestarts{policy: cnr.cfg.WorkerRestart}
+	for {
+		established, err := cnr.consume(ctx, params, handler, inFlight)
+		if cnr.isStopping() {
+			return nil
+		}
+
+		if ctx.Err() != nil || cnr.cfg.WorkerRestart == nil {
+			return err
+		}
+
+		delay, ok := restarts.next(established)
+		if !ok {
+			return fmt.Errorf("worker %s gave up after %d attempts: %w", params.Consumer, restarts.attempt, err)
+		}
+
+		logrus.WithError(err).Warningf("worker %s stopped, restart in %s", params.Consumer, delay)
+		if err = sleepCtx(restartCtx, delay); err == nil {
+			err = cnr.connection.waitReady(restartCtx)
+		}
+
+		if err != nil {
+			if cnr.isStopping() {
+				return nil
+			}
+
+			return err
+		}
+	}
+}
+
+// Shutdown - graceful consumer stop: cancels every worker's consumer tag, stops accepting new deliveries,
+// waits for running handlers and closes worker channels. If ctx is done earlier, handlers context is cancelled
+// and ctx error is returned
+func (cnr *Consumer) Shutdown(ctx context.Context) error {
+	cnr.mu.Lock()
+	if !cnr.shutdown {
+		cnr.shutdown = true
+		close(cnr.stopping)
+	}
+	cnr.mu.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		cnr.workers.Wait()
+		close(stopped)
+	}()
+
+	defer cnr.done()
+	select {
+	case <-stopped:
+		logrus.Info("consumer is shut down")
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("consumer shutdown: %w", ctx.Err())
+	}
+}
+
+// isStopping - true after Shutdown call
+func (cnr *Consumer) isStopping() bool {
+	select {
+	case <-cnr.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// consume - single consuming session on a new channel, established is true if Consume call was successful.
+// inFlight limits async handlers count, nil means no limit
+func (cnr *Consumer) consume(
+	ctx context.Context,
+	params *ConsumeParams,
+	handler MessageHandler,
+	inFlight chan struct{},
+) (established bool, err error) {
 	// channel per every worker
 	channel, err := cnr.connection.Channel()
 	if err != nil {
-		return err
+		return
+	}
+
+	// handlers - running handlers of this session, channel is closed after them for successful acks
+	var handlers sync.WaitGroup
+	defer func() {
+		cnr.drain(&handlers)
+		channel.Close()
+	}()
+
+	if qos := cnr.qos(params); qos != nil {
+		if err = channel.Qos(qos.PrefetchCount, qos.PrefetchSize, qos.Global); err != nil {
+			err = fmt.Errorf("channel qos error: %w", err)
+			return
+		}
 	}
 
 	deliveryChan, err := channel.Consume(
@@ -91,34 +271,181 @@ func (cnr *Consumer) StartWorker(ctx context.Context, params *ConsumeParams, han
 	)
 
 	if err != nil {
-		return err
+		return
 	}
 
+	established = true
 	workerCtx, doneFunc := context.WithCancel(ctx)
 	defer doneFunc()
 
-	channelErrors := channel.NotifyClose(make(chan *amqp.Error))
+	channelErrors := channel.NotifyClose(make(chan *amqp.Error, 1))
+	cancelChan := channel.NotifyCancel(make(chan string, 1))
 	for {
 		select {
 		// message receiving
-		case msg := <-deliveryChan:
+		case msg, ok := <-deliveryChan:
+			// delivery channel is closed on channel shutdown or consumer cancel
+			if !ok {
+				err = cnr.closedDeliveryErr(channel, channelErrors, params.Consumer)
+				return
+			}
+
+			// select doesn't prioritize cases, so shutdown is checked before every delivery
+			if cnr.isStopping() {
+				err = cnr.stop(channel, params.Consumer)
+				return
+			}
+
 			if cnr.cfg.Synchronous {
-				cnr.handleMsg(ctx, channel, &msg, handler)
-			} else {
-				go cnr.handleMsg(ctx, channel, &msg, handler)
+				cnr.handleMsg(ctx, channel, &msg, handler, params.AutoAck)
+				continue
 			}
+
+			// backpressure: wait for a free slot before the next delivery
+			if err = cnr.acquireSlot(workerCtx, inFlight); err != nil {
+				// delivery is not handled, it will be requeued by broker on channel close
+				if errors.Is(err, ErrConsumerShutdown) {
+					err = cnr.stop(channel, params.Consumer)
+				}
+				return
+			}
+
+			handlers.Add(1)
+			go func(msg amqp.Delivery) {
+				defer handlers.Done()
+				if inFlight != nil {
+					defer func() { <-inFlight }()
+				}
+
+				cnr.handleMsg(ctx, channel, &msg, handler, params.AutoAck)
+			}(msg)
+		// graceful shutdown
+		case <-cnr.stopping:
+			err = cnr.stop(channel, params.Consumer)
+			return
+		// listener for basic.cancel from broker
+		case tag := <-cancelChan:
+			err = cnr.cancelled(tag)
+			return
 		// listener for amqp.Channel errors
 		case notifyErr := <-channelErrors:
-			return notifyErr
+			if notifyErr == nil {
+				err = amqp.ErrClosed
+			} else {
+				err = notifyErr
+			}
+			return
 		// stop worker by context
 		case <-workerCtx.Done():
-			return workerCtx.Err()
+			err = workerCtx.Err()
+			return
+		}
+	}
+}
+
+// qos - prefetch settings of worker channel, ConsumeParams.Qos overrides ConsumerConfig.Qos
+func (cnr *Consumer) qos(params *ConsumeParams) *QosParams {
+	if params.Qos != nil {
+		return params.Qos
+	}
+
+	return cnr.cfg.Qos
+}
+
+// acquireSlot - waits for a free async handler slot, nil inFlight means no limit.
+// Returns ErrConsumerShutdown if Shutdown was called meanwhile
+func (cnr *Consumer) acquireSlot(ctx context.Context, inFlight chan struct{}) error {
+	if inFlight == nil {
+		return nil
+	}
+
+	select {
+	case inFlight <- struct{}{}:
+		return nil
+	case <-cnr.stopping:
+		return ErrConsumerShutdown
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stop - cancels consumer tag on shutdown, so broker stops sending new deliveries
+func (cnr *Consumer) stop(channel *amqp.Channel, tag string) error {
+	if err := channel.Cancel(tag, false); err != nil {
+		logrus.WithError(err).Warningf("unable to cancel consumer %s", tag)
+		return err
+	}
+
+	return nil
+}
+
+// drain - waits for session handlers on shutdown, wait is interrupted when consumer ctx is done
+func (cnr *Consumer) drain(handlers *sync.WaitGroup) {
+	if !cnr.isStopping() {
+		return
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		handlers.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-cnr.ctx.Done():
+	}
+}
+
+// next - pause before the next restart, restarts limit applies to consecutive failed attempts only,
+// so counter is reset if consuming was established before failure
+func (wr *workerRestarts) next(established bool) (time.Duration, bool) {
+	if established {
+		wr.attempt = 0
+	}
+
+	wr.attempt++
+	return wr.policy.Delay(wr.attempt)
+}
+
+// closedDeliveryErr - reason of delivery channel closing: channel error or consumer cancel
+func (cnr *Consumer) closedDeliveryErr(channel *amqp.Channel, channelErrors chan *amqp.Error, tag string) error {
+	// channel errors are sent before delivery channel closing
+	select {
+	case notifyErr := <-channelErrors:
+		if notifyErr != nil {
+			return notifyErr
 		}
+	default:
 	}
+
+	if channel.IsClosed() {
+		return amqp.ErrClosed
+	}
+
+	return cnr.cancelled(tag)
 }
 
-// handleMsg just calls handler function with additional logs
-func (cnr *Consumer) handleMsg(ctx context.Context, channel *amqp.Channel, msg *amqp.Delivery, handler MessageHandler) {
+// cancelled - runs OnCancel hook and returns ErrConsumerCancelled
+func (cnr *Consumer) cancelled(tag string) error {
+	logrus.WithField("tag", tag).Warning("consumer cancelled by broker")
+	if cnr.cfg.OnCancel != nil {
+		cnr.cfg.OnCancel(tag)
+	}
+
+	return fmt.Errorf("%w: %s", ErrConsumerCancelled, tag)
+}
+
+// handleMsg just calls handler function with additional logs.
+// Delivery is settled through guard, panic and timeout actions are applied only to not settled deliveries
+// and are skipped for auto acked ones
+func (cnr *Consumer) handleMsg(
+	ctx context.Context,
+	channel *amqp.Channel,
+	msg *amqp.Delivery,
+	handler MessageHandler,
+	autoAck bool,
+) {
 	logEntry := logrus.WithFields(logrus.Fields{
 		"exchange":     msg.Exchange,
 		"routing_key":  msg.RoutingKey,
@@ -127,8 +454,59 @@ func (cnr *Consumer) handleMsg(ctx context.Context, channel *amqp.Channel, msg *
 		"tag":          msg.ConsumerTag,
 	})
 
-	if err := handler.Handle(ctx, channel, msg); err != nil {
+	guard, guarded := newSettleGuard(msg)
+	if autoAck {
+		// auto acked delivery is already settled by broker
+		guard.settle()
+	}
+
+	ctx, cancel, expired := cnr.withDeadline(ctx, msg, guard, logEntry)
+	defer cancel()
+	if expired {
+		return
+	}
+
+	defer func() {
+		if value := recover(); value != nil {
+			cnr.recovered(msg, guard, &PanicError{Value: value, Stack: debug.Stack()}, logEntry)
+		}
+	}()
+
+	if err := handler.Handle(ctx, channel, guarded); err != nil {
 		logEntry.Errorf("msg handling err: %s", err)
 	}
 	logEntry.Info("message handled")
 }
+
+// recovered - applies ConsumerConfig.PanicAction to message after handler panic and runs OnPanic hook
+func (cnr *Consumer) recovered(msg *amqp.Delivery, guard *settleGuard, err *PanicError, logEntry *logrus.Entry) {
+	atomic.AddUint64(&cnr.panics, 1)
+	logEntry.WithField("stack", string(err.Stack)).Error(err)
+
+	// message may be already settled by handler before panic or by broker in auto ack mode
+	if aErr := guard.apply(cnr.cfg.PanicAction); errors.Is(aErr, ErrDeliverySettled) {
+		logEntry.Info("message is already settled, panic action is skipped")
+	} else if aErr != nil {
+		logEntry.WithError(aErr).Error("unable to apply panic action")
+	}
+
+	if cnr.cfg.OnPanic != nil {
+		cnr.cfg.OnPanic(msg, err)
+	}
+}
+
+// Panics - count of recovered handler panics
+func (cnr *Consumer) Panics() uint64 {
+	return atomic.LoadUint64(&cnr.panics)
+}
+
+// Error - error interface implementation
+func (pe *PanicError) Error() string {
+	return fmt.Sprintf("message handler panic: %v", pe.Value)
+}
+
+// Unwrap - errors.Unwrap support for panics with error value
+func (pe *PanicError) Unwrap() error {
+	err, _ := pe.Value.(error)
+	return err
+}
@@ -0,0 +1,248 @@
+package rmq
+
+import (
+	"context"
+	"errors"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_Consumer_handleMsgPanic(t *testing.T) {
+	var hooked *PanicError
+	consumer := NewConsumer(NewConnection(context.Background(), nil), &ConsumerConfig{
+		OnPanic: func(_ *amqp.Delivery, err *PanicError) {
+			hooked = err
+		},
+	})
+
+	handler := MessageHandlerFunc(func(context.Context, *amqp.Channel, *amqp.Delivery) error {
+		panic(io.ErrUnexpectedEOF)
+	})
+
+	ack := &testAcknowledger{}
+	consumer.handleMsg(context.Background(), nil, &amqp.Delivery{Acknowledger: ack}, handler, false)
+
+	if ack.rejects != 1 {
+		t.Errorf("rejects = %d, default panic action must reject message", ack.rejects)
+	}
+
+	if consumer.Panics() != 1 {
+		t.Errorf("Panics() = %d, want 1", consumer.Panics())
+	}
+
+	if hooked == nil || !errors.Is(hooked, io.ErrUnexpectedEOF) || !strings.Contains(string(hooked.Stack), "handleMsg") {
+		t.Errorf("OnPanic err = %v, want panic value and stack", hooked)
+	}
+}
+
+func Test_Consumer_handleMsgPanicSettled(t *testing.T) {
+	tests := []struct {
+		name    string
+		ack     bool
+		autoAck bool
+		want    int
+	}{
+		{"Not settled", false, false, 1},
+		{"Acked before panic", true, false, 0},
+		{"Auto ack", false, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			consumer := NewConsumer(NewConnection(context.Background(), nil), &ConsumerConfig{})
+			handler := MessageHandlerFunc(func(_ context.Context, _ *amqp.Channel, msg *amqp.Delivery) error {
+				if tt.ack {
+					_ = msg.Ack(false)
+				}
+				panic("handler failed")
+			})
+
+			ack := &testAcknowledger{}
+			consumer.handleMsg(context.Background(), nil, &amqp.Delivery{Acknowledger: ack}, handler, tt.autoAck)
+
+			if ack.rejects != tt.want {
+				t.Errorf("rejects = %d, want %d", ack.rejects, tt.want)
+			}
+		})
+	}
+}
+
+func Test_workerRestarts_next(t *testing.T) {
+	restarts := &workerRestarts{policy: &ConstantBackoff{Interval: time.Second, MaxAttempts: 3}}
+	steps := []struct {
+		established bool
+		wantAttempt int
+		wantOk      bool
+	}{
+		{established: false, wantAttempt: 1, wantOk: true},
+		{established: false, wantAttempt: 2, wantOk: true},
+		// worker has consumed between failures, limit applies to consecutive failures only
+		{established: true, wantAttempt: 1, wantOk: true},
+		{established: false, wantAttempt: 2, wantOk: true},
+		{established: false, wantAttempt: 3, wantOk: false},
+	}
+
+	for num, step := range steps {
+		delay, ok := restarts.next(step.established)
+		if restarts.attempt != step.wantAttempt || ok != step.wantOk {
+			t.Fatalf("step %d: attempt = %d, ok = %v, want %d, %v", num, restarts.attempt, ok, step.wantAttempt, step.wantOk)
+		}
+
+		if ok && delay != time.Second {
+			t.Errorf("step %d: delay = %s, want %s", num, delay, time.Second)
+		}
+	}
+}
+
+func Test_Consumer_closedDeliveryErr(t *testing.T) {
+	var cancelledTags []string
+	consumer := NewConsumer(NewConnection(context.Background(), nil), &ConsumerConfig{
+		OnCancel: func(consumerTag string) {
+			cancelledTags = append(cancelledTags, consumerTag)
+		},
+	})
+
+	channelErr := &amqp.Error{Code: amqp.ChannelError, Reason: "channel error"}
+	tests := []struct {
+		name    string
+		notify  *amqp.Error
+		wantErr error
+		wantTag bool
+	}{
+		{
+			name:    "channel error",
+			notify:  channelErr,
+			wantErr: channelErr,
+		},
+		{
+			name:    "cancel by broker",
+			wantErr: ErrConsumerCancelled,
+			wantTag: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cancelledTags = nil
+			channelErrors := make(chan *amqp.Error, 1)
+			if tt.notify != nil {
+				channelErrors <- tt.notify
+			}
+
+			err := consumer.closedDeliveryErr(&amqp.Channel{}, channelErrors, "tag")
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("closedDeliveryErr() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.wantTag != (len(cancelledTags) == 1 && cancelledTags[0] == "tag") {
+				t.Errorf("OnCancel calls = %v, want called: %v", cancelledTags, tt.wantTag)
+			}
+		})
+	}
+}
+
+func Test_Consumer_qos(t *testing.T) {
+	cfgQos := &QosParams{PrefetchCount: 10}
+	paramsQos := &QosParams{PrefetchCount: 1, Global: true}
+
+	tests := []struct {
+		name   string
+		cfg    *QosParams
+		params *QosParams
+		want   *QosParams
+	}{
+		{name: "no limits"},
+		{name: "consumer config", cfg: cfgQos, want: cfgQos},
+		{name: "consume params override", cfg: cfgQos, params: paramsQos, want: paramsQos},
+		{name: "consume params only", params: paramsQos, want: paramsQos},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			consumer := NewConsumer(NewConnection(context.Background(), nil), &ConsumerConfig{Qos: tt.cfg})
+			if got := consumer.qos(&ConsumeParams{Qos: tt.params}); got != tt.want {
+				t.Errorf("qos() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_Consumer_acquireSlot(t *testing.T) {
+	consumer := NewConsumer(NewConnection(context.Background(), nil), &ConsumerConfig{MaxInFlight: 1})
+	if err := consumer.acquireSlot(context.Background(), nil); err != nil {
+		t.Fatalf("acquireSlot() without limit error = %v", err)
+	}
+
+	inFlight := make(chan struct{}, 1)
+	if err := consumer.acquireSlot(context.Background(), inFlight); err != nil {
+		t.Fatalf("acquireSlot() error = %v", err)
+	}
+
+	// all slots are busy
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	if err := consumer.acquireSlot(ctx, inFlight); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("acquireSlot() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// finished handler frees its slot
+	<-inFlight
+	if err := consumer.acquireSlot(context.Background(), inFlight); err != nil {
+		t.Fatalf("acquireSlot() after release error = %v", err)
+	}
+
+	if err := consumer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	if err := consumer.acquireSlot(context.Background(), inFlight); !errors.Is(err, ErrConsumerShutdown) {
+		t.Errorf("acquireSlot() error = %v, want %v", err, ErrConsumerShutdown)
+	}
+}
+
+func TestConsumer_StartWorkerAfterShutdown(t *testing.T) {
+	consumer := NewConsumer(NewConnection(context.Background(), nil), &ConsumerConfig{})
+	if err := consumer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	err := consumer.StartWorker(context.Background(), &ConsumeParams{Queue: "test"}, MessageHandlerFunc(
+		func(context.Context, *amqp.Channel, *amqp.Delivery) error { return nil },
+	))
+	if !errors.Is(err, ErrConsumerShutdown) {
+		t.Errorf("StartWorker() error = %v, want %v", err, ErrConsumerShutdown)
+	}
+}
+
+func TestConsumer_ShutdownTimeout(t *testing.T) {
+	consumer := NewConsumer(NewConnection(context.Background(), nil), &ConsumerConfig{})
+
+	// worker with a running handler
+	var handlers sync.WaitGroup
+	handlers.Add(1)
+	defer handlers.Done()
+	consumer.workers.Add(1)
+	drained := make(chan struct{})
+	go func() {
+		defer consumer.workers.Done()
+		<-consumer.stopping
+		consumer.drain(&handlers)
+		close(drained)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	if err := consumer.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// consumer ctx is cancelled on timeout, so drain doesn't wait for handler anymore
+	select {
+	case <-drained:
+	case <-time.After(time.Second):
+		t.Fatalf("drain is not interrupted after Shutdown timeout")
+	}
+}
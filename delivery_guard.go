@@ -0,0 +1,79 @@
+package rmq
+
+import (
+	"errors"
+	"fmt"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"sync"
+)
+
+// ErrDeliverySettled - message is already acked or nacked, e.g. by TimeoutAction after deadline
+var ErrDeliverySettled = errors.New("delivery is already settled")
+
+// settleGuard - Acknowledger wrapper, which allows only the first ack/nack/reject of delivery,
+// so consumer actions (panic, timeout) never settle message twice
+type settleGuard struct {
+	// msg - original delivery
+	msg *amqp.Delivery
+	// mu - guards settled
+	mu      sync.Mutex
+	settled bool
+}
+
+// newSettleGuard - returns guard and msg copy, which is settled through the guard
+func newSettleGuard(msg *amqp.Delivery) (*settleGuard, *amqp.Delivery) {
+	guard := &settleGuard{msg: msg}
+	delivery := *msg
+	delivery.Acknowledger = guard
+
+	return guard, &delivery
+}
+
+// apply - applies action to delivery, if it is not settled yet
+func (sg *settleGuard) apply(action MsgAction) error {
+	if !sg.settle() {
+		return ErrDeliverySettled
+	}
+
+	return doMsgAction(sg.msg, action)
+}
+
+// settle - marks delivery as settled, returns false if it is already settled
+func (sg *settleGuard) settle() bool {
+	sg.mu.Lock()
+	defer sg.mu.Unlock()
+
+	if sg.settled {
+		return false
+	}
+
+	sg.settled = true
+	return true
+}
+
+// Ack - Acknowledger implementation
+func (sg *settleGuard) Ack(_ uint64, multiple bool) error {
+	if !sg.settle() {
+		return fmt.Errorf("ack: %w", ErrDeliverySettled)
+	}
+
+	return sg.msg.Ack(multiple)
+}
+
+// Nack - Acknowledger implementation
+func (sg *settleGuard) Nack(_ uint64, multiple, requeue bool) error {
+	if !sg.settle() {
+		return fmt.Errorf("nack: %w", ErrDeliverySettled)
+	}
+
+	return sg.msg.Nack(multiple, requeue)
+}
+
+// Reject - Acknowledger implementation
+func (sg *settleGuard) Reject(_ uint64, requeue bool) error {
+	if !sg.settle() {
+		return fmt.Errorf("reject: %w", ErrDeliverySettled)
+	}
+
+	return sg.msg.Reject(requeue)
+}
@@ -14,7 +14,8 @@ type (
 	}
 	// ExchangeManager - exchanges manager
 	ExchangeManager struct {
-		channel *amqp.Channel
+		channel  *amqp.Channel
+		recorder *schemaRecorder
 	}
 )
 
@@ -40,8 +41,11 @@ func (em *ExchangeManager) Delete(deleteParams *DeleteParams) (err error) {
 	err = em.channel.ExchangeDelete(deleteParams.Name, deleteParams.IfUnused, deleteParams.NoWait)
 	if err != nil {
 		err = fmt.Errorf("exchange delete error: %w", err)
+		return
 	}
 
+	em.recorder.exchangeDeleted(*deleteParams)
+
 	return
 }
 
@@ -81,8 +85,11 @@ func (em *ExchangeManager) Declare(declareParams *DeclareParams) (err error) {
 
 	if err != nil {
 		err = fmt.Errorf("exchange declare error: %w", err)
+		return
 	}
 
+	em.recorder.exchangeDeclared(*declareParams)
+
 	return
 }
 
@@ -114,8 +121,11 @@ func (em *ExchangeManager) Bind(bindParams *ExchangeBindParams) (err error) {
 
 	if err != nil {
 		err = fmt.Errorf("exchange bind error: %w", err)
+		return
 	}
 
+	em.recorder.exchangeBound(*bindParams)
+
 	return
 }
 
@@ -147,8 +157,11 @@ func (em *ExchangeManager) Unbind(bindParams *ExchangeBindParams) (err error) {
 
 	if err != nil {
 		err = fmt.Errorf("exchange unbind error: %w", err)
+		return
 	}
 
+	em.recorder.exchangeUnbound(*bindParams)
+
 	return
 }
 
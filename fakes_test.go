@@ -0,0 +1,36 @@
+package rmq
+
+import "sync"
+
+// testAcknowledger - amqp.Acknowledger fake, which counts settlements
+type testAcknowledger struct {
+	mu                   sync.Mutex
+	acks, nacks, rejects int
+}
+
+func (ta *testAcknowledger) Ack(uint64, bool) error {
+	ta.mu.Lock()
+	defer ta.mu.Unlock()
+	ta.acks++
+	return nil
+}
+
+func (ta *testAcknowledger) Nack(uint64, bool, bool) error {
+	ta.mu.Lock()
+	defer ta.mu.Unlock()
+	ta.nacks++
+	return nil
+}
+
+func (ta *testAcknowledger) Reject(uint64, bool) error {
+	ta.mu.Lock()
+	defer ta.mu.Unlock()
+	ta.rejects++
+	return nil
+}
+
+func (ta *testAcknowledger) settled() int {
+	ta.mu.Lock()
+	defer ta.mu.Unlock()
+	return ta.acks + ta.nacks + ta.rejects
+}
@@ -39,7 +39,8 @@ func NewDelayedRetryMessageHandler(
 
 // Handle - redeclared DefaultMessageHandler.Handle method, main difference in error handling logic
 // if HandleFunc returns err, by default message will be rejected
-// but if message has x-death header and count < MaxRetriesCount -> message will be acknowledged and resented to delay queue
+// but if message has x-death header and count < MaxRetriesCount -> message will be acknowledged and resented to delay queue.
+// Permanent errors are rejected without retries, RetryAfter errors are delayed for their own delay instead of Delay
 func (fmh *DelayedRetryMessageHandler) Handle(ctx context.Context, channel *amqp.Channel, msg *amqp.Delivery) (err error) {
 	if err = fmh.BeforeHandle(ctx, channel, msg); err != nil {
 		return
@@ -55,11 +56,9 @@ func (fmh *DelayedRetryMessageHandler) Handle(ctx context.Context, channel *amqp
 		var aErr, pErr error
 		fallbackAction := ActionReject
 
-		retriesCount := getExpiredMsgRetriesCount(msg)
-
-		if retriesCount < fmh.MaxRetriesCount {
+		if delay, retry := fmh.retryDelay(msg, err); retry {
 			newMsg := createPublishingFromDelivery(msg)
-			newMsg.Expiration = durationToExpiration(fmh.Delay)
+			newMsg.Expiration = durationToExpiration(delay)
 			pErr = channel.Publish(
 				fmh.DelayExchangeName,
 				fmh.DelayQueueRoutingKey,
@@ -89,3 +88,16 @@ func (fmh *DelayedRetryMessageHandler) Handle(ctx context.Context, channel *amqp
 
 	return
 }
+
+// retryDelay - delay of message in delay queue, retry is false for Permanent errors and exhausted retries
+func (fmh *DelayedRetryMessageHandler) retryDelay(msg *amqp.Delivery, err error) (delay time.Duration, retry bool) {
+	if IsPermanent(err) || getExpiredMsgRetriesCount(msg) >= fmh.MaxRetriesCount {
+		return 0, false
+	}
+
+	if retryDelay, ok := RetryDelay(err); ok {
+		return retryDelay, true
+	}
+
+	return fmh.Delay, true
+}
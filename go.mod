@@ -4,6 +4,7 @@ go 1.17
 
 require (
 	github.com/jackc/puddle v1.2.1
+	github.com/mattn/go-sqlite3 v1.14.17
 	github.com/rabbitmq/amqp091-go v1.3.0
 	github.com/sirupsen/logrus v1.8.1
 	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
@@ -53,7 +53,9 @@ func (dmh *DefaultMessageHandler) BeforeHandle(ctx context.Context, channel *amq
 }
 
 // Handle - main handle function, wraps result of HandleFunc to MessageResultContainer
-// if HandleFunc is nil -> returns nil, msg will be nacked by AfterHandle event
+// if HandleFunc is nil -> returns nil, msg will be nacked by AfterHandle event.
+// If HandleFunc returns error, message is rejected for Permanent errors and requeued immediately otherwise.
+// RetryAfter delay is ignored, use DelayedRetryMessageHandler for delayed retries
 func (dmh *DefaultMessageHandler) Handle(ctx context.Context, channel *amqp.Channel, msg *amqp.Delivery) (err error) {
 	if err = dmh.BeforeHandle(ctx, channel, msg); err != nil {
 		return
@@ -66,9 +68,9 @@ func (dmh *DefaultMessageHandler) Handle(ctx context.Context, channel *amqp.Chan
 
 	action, err := dmh.HandleFunc(ctx, channel, msg)
 	if err != nil {
-		aErr := dmh.DoMsgAction(msg, ActionRequeue)
+		aErr := dmh.DoMsgAction(msg, errorAction(err))
 		if aErr != nil {
-			err = fmt.Errorf("requeue error: %s, prev err: %w", aErr.Error(), err)
+			err = fmt.Errorf("error while broker notify action: %s, prev err: %w", aErr.Error(), err)
 		}
 
 		return
@@ -100,8 +102,23 @@ func (dmh *DefaultMessageHandler) AfterHandle(
 	return nil
 }
 
+// errorAction - action for HandleFunc error. Message is not held for RetryAfter delay: unacked message
+// would block synchronous worker and could be settled by TimeoutAction meanwhile
+func errorAction(err error) MsgAction {
+	if IsPermanent(err) {
+		return ActionReject
+	}
+
+	return ActionRequeue
+}
+
 // DoMsgAction - do ack or nack job with readed message
-func (dmh *DefaultMessageHandler) DoMsgAction(msg *amqp.Delivery, action MsgAction) (err error) {
+func (dmh *DefaultMessageHandler) DoMsgAction(msg *amqp.Delivery, action MsgAction) error {
+	return doMsgAction(msg, action)
+}
+
+// doMsgAction - notifies broker about message handling result
+func doMsgAction(msg *amqp.Delivery, action MsgAction) (err error) {
 	switch action {
 	case ActionAck:
 		err = msg.Ack(false)
@@ -0,0 +1,76 @@
+package rmq
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// errorKind - handler error classification, see Permanent, Transient and RetryAfter
+type errorKind int
+
+const (
+	kindPermanent errorKind = 1 + iota
+	kindTransient
+	kindRetryAfter
+)
+
+// HandlerError - classified error of HandleFunc, defines what happens with the message
+type HandlerError struct {
+	Err  error
+	kind errorKind
+	// Delay - pause before retry, is set for RetryAfter errors
+	Delay time.Duration
+}
+
+// Permanent - marks error as permanent (bad payload, etc.): message is rejected without retries
+// and dead-lettered if queue has DLX
+func Permanent(err error) error {
+	return &HandlerError{Err: err, kind: kindPermanent}
+}
+
+// Transient - marks error as temporary: message is requeued by DefaultMessageHandler
+// and resent to delay queue by DelayedRetryMessageHandler
+func Transient(err error) error {
+	return &HandlerError{Err: err, kind: kindTransient}
+}
+
+// RetryAfter - marks error as temporary with retry delay: DelayedRetryMessageHandler uses delay as message
+// expiration in delay queue. Delay is ignored by handlers without delay queue: DefaultMessageHandler
+// requeues message immediately, the same as for Transient and not classified errors
+func RetryAfter(err error, delay time.Duration) error {
+	return &HandlerError{Err: err, kind: kindRetryAfter, Delay: delay}
+}
+
+// IsPermanent - checks if err or any wrapped error is marked by Permanent
+func IsPermanent(err error) bool {
+	var hErr *HandlerError
+	return errors.As(err, &hErr) && hErr.kind == kindPermanent
+}
+
+// RetryDelay - delay of error, marked by RetryAfter
+func RetryDelay(err error) (time.Duration, bool) {
+	var hErr *HandlerError
+	if errors.As(err, &hErr) && hErr.kind == kindRetryAfter {
+		return hErr.Delay, true
+	}
+
+	return 0, false
+}
+
+// Error - error interface implementation
+func (he *HandlerError) Error() string {
+	switch he.kind {
+	case kindPermanent:
+		return fmt.Sprintf("permanent error: %s", he.Err)
+	case kindRetryAfter:
+		return fmt.Sprintf("retry after %s: %s", he.Delay, he.Err)
+	default:
+		return fmt.Sprintf("transient error: %s", he.Err)
+	}
+}
+
+// Unwrap - errors.Unwrap support
+func (he *HandlerError) Unwrap() error {
+	return he.Err
+}
@@ -0,0 +1,104 @@
+package rmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"testing"
+	"time"
+)
+
+func Test_DefaultMessageHandler_errorActions(t *testing.T) {
+	errBadPayload := errors.New("bad payload")
+	tests := []struct {
+		name                string
+		err                 error
+		wantNacks, wantRejs int
+	}{
+		{"Unclassified", errBadPayload, 1, 0},
+		{"Transient", Transient(errBadPayload), 1, 0},
+		{"Permanent", Permanent(errBadPayload), 0, 1},
+		{"Wrapped permanent", fmt.Errorf("decode: %w", Permanent(errBadPayload)), 0, 1},
+		{"Retry after", RetryAfter(errBadPayload, time.Millisecond), 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewDefaultMessageHandler(func(context.Context, *amqp.Channel, *amqp.Delivery) (MsgAction, error) {
+				return ActionAck, tt.err
+			})
+
+			ack := &testAcknowledger{}
+			err := handler.Handle(context.Background(), nil, &amqp.Delivery{Acknowledger: ack})
+			if !errors.Is(err, errBadPayload) {
+				t.Errorf("Handle() error = %v, want %v", err, errBadPayload)
+			}
+
+			if ack.nacks != tt.wantNacks || ack.rejects != tt.wantRejs {
+				t.Errorf("nacks = %d, rejects = %d, want %d, %d", ack.nacks, ack.rejects, tt.wantNacks, tt.wantRejs)
+			}
+		})
+	}
+}
+
+func Test_RetryDelay(t *testing.T) {
+	err := fmt.Errorf("wrapped: %w", RetryAfter(errors.New("busy"), time.Minute))
+	if delay, ok := RetryDelay(err); !ok || delay != time.Minute {
+		t.Errorf("RetryDelay() = %v, %v, want %v, true", delay, ok, time.Minute)
+	}
+
+	if _, ok := RetryDelay(Transient(errors.New("busy"))); ok {
+		t.Errorf("RetryDelay() of transient error must not be ok")
+	}
+
+	if IsPermanent(Transient(errors.New("busy"))) {
+		t.Errorf("IsPermanent() of transient error = true")
+	}
+}
+
+func Test_DelayedRetryMessageHandler_retryDelay(t *testing.T) {
+	handler := NewDelayedRetryMessageHandler("delay", "delay", time.Second, 3, nil)
+	exhausted := amqp.Delivery{Headers: amqp.Table{
+		"x-death": []interface{}{amqp.Table{"reason": "expired", "count": int64(3)}},
+	}}
+
+	tests := []struct {
+		name      string
+		msg       amqp.Delivery
+		err       error
+		wantDelay time.Duration
+		wantRetry bool
+	}{
+		{"Unclassified", amqp.Delivery{}, errors.New("failed"), time.Second, true},
+		{"Transient", amqp.Delivery{}, Transient(errors.New("failed")), time.Second, true},
+		{"Retry after", amqp.Delivery{}, RetryAfter(errors.New("busy"), time.Minute), time.Minute, true},
+		{"Permanent", amqp.Delivery{}, Permanent(errors.New("bad payload")), 0, false},
+		{"Retries exhausted", exhausted, RetryAfter(errors.New("busy"), time.Minute), 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, retry := handler.retryDelay(&tt.msg, tt.err)
+			if delay != tt.wantDelay || retry != tt.wantRetry {
+				t.Errorf("retryDelay() = %v, %v, want %v, %v", delay, retry, tt.wantDelay, tt.wantRetry)
+			}
+		})
+	}
+}
+
+func Test_DelayedRetryMessageHandler_Permanent(t *testing.T) {
+	handler := NewDelayedRetryMessageHandler("delay", "delay", time.Second, 3,
+		func(context.Context, *amqp.Channel, *amqp.Delivery) (MsgAction, error) {
+			return 0, Permanent(errors.New("bad payload"))
+		},
+	)
+
+	// permanent error is rejected without publishing to delay queue, so channel is not used
+	ack := &testAcknowledger{}
+	if err := handler.Handle(context.Background(), nil, &amqp.Delivery{Acknowledger: ack}); !IsPermanent(err) {
+		t.Errorf("Handle() error = %v, want permanent error", err)
+	}
+
+	if ack.rejects != 1 || ack.acks != 0 {
+		t.Errorf("rejects = %d, acks = %d, want 1, 0", ack.rejects, ack.acks)
+	}
+}
@@ -0,0 +1,106 @@
+package rmq
+
+import (
+	"context"
+	"errors"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"time"
+)
+
+// DeadlineHeader - header with message handling deadline, see ConsumerConfig.DeadlineFromMessage
+const DeadlineHeader = "x-deadline"
+
+// withDeadline - limits handler ctx by HandlerTimeout and message deadline, applies TimeoutAction at deadline,
+// if message is not settled by handler yet. Expired is true if message deadline has already passed,
+// TimeoutAction is applied immediately in this case and handler must be skipped
+func (cnr *Consumer) withDeadline(
+	ctx context.Context,
+	msg *amqp.Delivery,
+	guard *settleGuard,
+	logEntry *logrus.Entry,
+) (_ context.Context, _ context.CancelFunc, expired bool) {
+	var deadline time.Time
+	if cnr.cfg.HandlerTimeout > 0 {
+		deadline = time.Now().Add(cnr.cfg.HandlerTimeout)
+	}
+
+	if cnr.cfg.DeadlineFromMessage {
+		if msgDeadline, ok := messageDeadline(msg); ok && (deadline.IsZero() || msgDeadline.Before(deadline)) {
+			deadline = msgDeadline
+		}
+	}
+
+	if deadline.IsZero() {
+		return ctx, func() {}, false
+	}
+
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	if !deadline.After(time.Now()) {
+		logEntry.Warning("message deadline has passed before handling")
+		cnr.timedOut(guard, logEntry)
+		return ctx, cancel, true
+	}
+
+	go func() {
+		<-ctx.Done()
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return
+		}
+
+		logEntry.Warning("message handling deadline exceeded")
+		cnr.timedOut(guard, logEntry)
+	}()
+
+	return ctx, cancel, false
+}
+
+// timedOut - applies TimeoutAction, if message is not settled by handler or broker (auto ack)
+func (cnr *Consumer) timedOut(guard *settleGuard, logEntry *logrus.Entry) {
+	if err := guard.apply(cnr.cfg.TimeoutAction); err != nil && !errors.Is(err, ErrDeliverySettled) {
+		logEntry.WithError(err).Error("unable to apply timeout action")
+	}
+}
+
+// messageDeadline - deadline from DeadlineHeader or Expiration property of message
+func messageDeadline(msg *amqp.Delivery) (time.Time, bool) {
+	if value, ok := msg.Headers[DeadlineHeader]; ok {
+		return parseDeadline(value)
+	}
+
+	if msg.Expiration == "" {
+		return time.Time{}, false
+	}
+
+	ttl, err := strconv.ParseInt(msg.Expiration, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	published := msg.Timestamp
+	if published.IsZero() {
+		published = time.Now()
+	}
+
+	return published.Add(time.Duration(ttl) * time.Millisecond), true
+}
+
+// parseDeadline - DeadlineHeader value parsing
+func parseDeadline(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, true
+	case int64:
+		return time.UnixMilli(v), true
+	case int32:
+		return time.UnixMilli(int64(v)), true
+	case int:
+		return time.UnixMilli(int64(v)), true
+	case string:
+		deadline, err := time.Parse(time.RFC3339Nano, v)
+		return deadline, err == nil
+	default:
+		return time.Time{}, false
+	}
+}
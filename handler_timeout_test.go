@@ -0,0 +1,102 @@
+package rmq
+
+import (
+	"context"
+	"errors"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"testing"
+	"time"
+)
+
+func Test_messageDeadline(t *testing.T) {
+	published := time.Date(2021, 11, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		msg    amqp.Delivery
+		want   time.Time
+		wantOk bool
+	}{
+		{"No deadline", amqp.Delivery{}, time.Time{}, false},
+		{"Expiration", amqp.Delivery{Timestamp: published, Expiration: "1500"}, published.Add(1500 * time.Millisecond), true},
+		{"Wrong expiration", amqp.Delivery{Timestamp: published, Expiration: "soon"}, time.Time{}, false},
+		{
+			"Unix ms header",
+			amqp.Delivery{Expiration: "1500", Headers: amqp.Table{DeadlineHeader: published.UnixMilli()}},
+			published,
+			true,
+		},
+		{"Timestamp header", amqp.Delivery{Headers: amqp.Table{DeadlineHeader: published}}, published, true},
+		{"RFC3339 header", amqp.Delivery{Headers: amqp.Table{DeadlineHeader: "2021-11-01T10:00:00Z"}}, published, true},
+		{"Wrong header", amqp.Delivery{Headers: amqp.Table{DeadlineHeader: true}}, time.Time{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := messageDeadline(&tt.msg)
+			if !got.Equal(tt.want) || ok != tt.wantOk {
+				t.Errorf("messageDeadline() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func Test_Consumer_handleMsgTimeout(t *testing.T) {
+	consumer := NewConsumer(NewConnection(context.Background(), nil), &ConsumerConfig{
+		HandlerTimeout: time.Millisecond * 10,
+		TimeoutAction:  ActionRequeue,
+	})
+
+	ack := &testAcknowledger{}
+	var ackErr error
+	handler := MessageHandlerFunc(func(ctx context.Context, _ *amqp.Channel, msg *amqp.Delivery) error {
+		<-ctx.Done()
+		// wait for timeout action
+		for ack.settled() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		ackErr = msg.Ack(false)
+		return ctx.Err()
+	})
+
+	consumer.handleMsg(context.Background(), nil, &amqp.Delivery{Acknowledger: ack}, handler, false)
+
+	if ack.nacks != 1 || ack.acks != 0 {
+		t.Errorf("nacks = %d, acks = %d, want timeout requeue only", ack.nacks, ack.acks)
+	}
+
+	if !errors.Is(ackErr, ErrDeliverySettled) {
+		t.Errorf("late ack error = %v, want %v", ackErr, ErrDeliverySettled)
+	}
+}
+
+func Test_Consumer_handleMsgExpired(t *testing.T) {
+	tests := []struct {
+		name      string
+		autoAck   bool
+		wantNacks int
+	}{
+		{"Manual ack", false, 1},
+		{"Auto ack", true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			consumer := NewConsumer(NewConnection(context.Background(), nil), &ConsumerConfig{
+				DeadlineFromMessage: true,
+				TimeoutAction:       ActionRequeue,
+			})
+
+			called := false
+			handler := MessageHandlerFunc(func(context.Context, *amqp.Channel, *amqp.Delivery) error {
+				called = true
+				return nil
+			})
+
+			ack := &testAcknowledger{}
+			msg := &amqp.Delivery{Acknowledger: ack, Headers: amqp.Table{DeadlineHeader: time.Now().Add(-time.Second)}}
+			consumer.handleMsg(context.Background(), nil, msg, handler, tt.autoAck)
+
+			if called || ack.settled() != tt.wantNacks || ack.nacks != tt.wantNacks {
+				t.Errorf("called = %v, nacks = %d, want handler skipped and %d nacks", called, ack.nacks, tt.wantNacks)
+			}
+		})
+	}
+}
@@ -1,11 +1,22 @@
 package rmq
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"strconv"
 	"time"
 )
 
+// newID - random hex identifier for message and correlation ids
+func newID() string {
+	id := make([]byte, 16)
+	// crypto/rand.Read error means broken system rand source, there is nothing to do with it
+	_, _ = rand.Read(id)
+
+	return hex.EncodeToString(id)
+}
+
 // durationToExpiration - convert duration to string int ms
 func durationToExpiration(duration time.Duration) string {
 	return strconv.FormatInt(int64(duration/time.Millisecond), 10)
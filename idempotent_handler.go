@@ -0,0 +1,190 @@
+package rmq
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+type (
+	// DedupStore - storage of processed messages keys, used by IdempotentHandler
+	DedupStore interface {
+		// Seen - checks if message with key was processed
+		Seen(ctx context.Context, key string) (bool, error)
+		// MarkProcessed - saves key of acknowledged message
+		MarkProcessed(ctx context.Context, key string) error
+	}
+
+	// IdempotentHandler - MessageHandler decorator, which acks duplicates without calling inner handler.
+	// Message key is marked as processed only after the inner handler acks the message without error
+	IdempotentHandler struct {
+		// Handler - inner handler
+		Handler MessageHandler
+		// Store - processed keys storage
+		Store DedupStore
+		// KeyHeader - header with deduplication key, MessageId is used if empty
+		KeyHeader string
+	}
+
+	// MemoryDedupStore - in-memory DedupStore with LRU eviction and keys TTL
+	MemoryDedupStore struct {
+		capacity int
+		ttl      time.Duration
+		// mu - guards items and order
+		mu    sync.Mutex
+		items map[string]*list.Element
+		// order - keys from the most to the least recently used
+		order *list.List
+		now   func() time.Time
+	}
+
+	// dedupItem - MemoryDedupStore list element value
+	dedupItem struct {
+		key       string
+		expiresAt time.Time
+	}
+
+	// ackRecorder - Acknowledger wrapper, which remembers successful ack
+	ackRecorder struct {
+		amqp.Acknowledger
+		acked bool
+	}
+)
+
+// NewIdempotentHandler - IdempotentHandler constructor, messages are deduplicated by MessageId
+func NewIdempotentHandler(handler MessageHandler, store DedupStore) *IdempotentHandler {
+	return &IdempotentHandler{Handler: handler, Store: store}
+}
+
+// Handle - MessageHandler implementation. Messages without key are passed to the inner handler as is,
+// on store errors message is handled too (at least once delivery)
+func (ih *IdempotentHandler) Handle(ctx context.Context, channel *amqp.Channel, msg *amqp.Delivery) error {
+	key := ih.key(msg)
+	if key == "" {
+		return ih.Handler.Handle(ctx, channel, msg)
+	}
+
+	seen, err := ih.Store.Seen(ctx, key)
+	if err != nil {
+		logrus.WithError(err).WithField("key", key).Warning("dedup store check error, message is handled")
+	}
+
+	if seen {
+		logrus.WithField("key", key).Info("duplicate message is acknowledged")
+		return msg.Ack(false)
+	}
+
+	recorder := &ackRecorder{Acknowledger: msg.Acknowledger}
+	delivery := *msg
+	delivery.Acknowledger = recorder
+
+	// handler may ack message and return error, e.g. DelayedRetryMessageHandler acks message,
+	// which is resent to delay queue, so its copy must not be treated as duplicate
+	err = ih.Handler.Handle(ctx, channel, &delivery)
+	if err != nil || !recorder.acked {
+		return err
+	}
+
+	if err = ih.Store.MarkProcessed(ctx, key); err != nil {
+		return fmt.Errorf("dedup store mark error: %w", err)
+	}
+
+	return nil
+}
+
+// key - deduplication key of message
+func (ih *IdempotentHandler) key(msg *amqp.Delivery) string {
+	if ih.KeyHeader == "" {
+		return msg.MessageId
+	}
+
+	value, ok := msg.Headers[ih.KeyHeader]
+	if !ok || value == nil {
+		return ""
+	}
+
+	return fmt.Sprint(value)
+}
+
+// Ack - Acknowledger implementation, remembers successful ack
+func (ar *ackRecorder) Ack(tag uint64, multiple bool) error {
+	if ar.Acknowledger == nil {
+		return amqp.ErrClosed
+	}
+
+	err := ar.Acknowledger.Ack(tag, multiple)
+	if err == nil {
+		ar.acked = true
+	}
+
+	return err
+}
+
+// NewMemoryDedupStore - MemoryDedupStore constructor. Capacity <= 0 means no size limit, ttl <= 0 means no expiration
+func NewMemoryDedupStore(capacity int, ttl time.Duration) *MemoryDedupStore {
+	return &MemoryDedupStore{
+		capacity: capacity,
+		ttl:      ttl,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+		now:      time.Now,
+	}
+}
+
+// Seen - DedupStore implementation
+func (mds *MemoryDedupStore) Seen(_ context.Context, key string) (bool, error) {
+	mds.mu.Lock()
+	defer mds.mu.Unlock()
+
+	element, ok := mds.items[key]
+	if !ok {
+		return false, nil
+	}
+
+	item := element.Value.(*dedupItem)
+	if mds.ttl > 0 && !mds.now().Before(item.expiresAt) {
+		mds.remove(element)
+		return false, nil
+	}
+
+	mds.order.MoveToFront(element)
+	return true, nil
+}
+
+// MarkProcessed - DedupStore implementation, the least recently used key is evicted on overflow
+func (mds *MemoryDedupStore) MarkProcessed(_ context.Context, key string) error {
+	mds.mu.Lock()
+	defer mds.mu.Unlock()
+
+	expiresAt := mds.now().Add(mds.ttl)
+	if element, ok := mds.items[key]; ok {
+		element.Value.(*dedupItem).expiresAt = expiresAt
+		mds.order.MoveToFront(element)
+		return nil
+	}
+
+	mds.items[key] = mds.order.PushFront(&dedupItem{key: key, expiresAt: expiresAt})
+	if mds.capacity > 0 && mds.order.Len() > mds.capacity {
+		mds.remove(mds.order.Back())
+	}
+
+	return nil
+}
+
+// Len - stored keys count, including expired but not evicted yet
+func (mds *MemoryDedupStore) Len() int {
+	mds.mu.Lock()
+	defer mds.mu.Unlock()
+
+	return mds.order.Len()
+}
+
+// remove - removes element, must be called under mu
+func (mds *MemoryDedupStore) remove(element *list.Element) {
+	mds.order.Remove(element)
+	delete(mds.items, element.Value.(*dedupItem).key)
+}
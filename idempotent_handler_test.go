@@ -0,0 +1,120 @@
+package rmq
+
+import (
+	"context"
+	"errors"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"testing"
+	"time"
+)
+
+func Test_IdempotentHandler_Handle(t *testing.T) {
+	calls := 0
+	action := ActionRequeue
+	handler := NewIdempotentHandler(
+		NewDefaultMessageHandler(func(context.Context, *amqp.Channel, *amqp.Delivery) (MsgAction, error) {
+			calls++
+			return action, nil
+		}),
+		NewMemoryDedupStore(10, time.Minute),
+	)
+
+	ack := &testAcknowledger{}
+	msg := &amqp.Delivery{Acknowledger: ack, MessageId: "id"}
+
+	// requeued message is not marked as processed
+	_ = handler.Handle(context.Background(), nil, msg)
+	action = ActionAck
+	_ = handler.Handle(context.Background(), nil, msg)
+	// duplicate is acked without inner handler call
+	if err := handler.Handle(context.Background(), nil, msg); err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+
+	if calls != 2 || ack.nacks != 1 || ack.acks != 2 {
+		t.Errorf("calls = %d, nacks = %d, acks = %d, want 2, 1, 2", calls, ack.nacks, ack.acks)
+	}
+
+	// messages without key are not deduplicated
+	_ = handler.Handle(context.Background(), nil, &amqp.Delivery{Acknowledger: ack})
+	_ = handler.Handle(context.Background(), nil, &amqp.Delivery{Acknowledger: ack})
+	if calls != 4 {
+		t.Errorf("calls = %d, want 4", calls)
+	}
+}
+
+func Test_IdempotentHandler_key(t *testing.T) {
+	tests := []struct {
+		name      string
+		keyHeader string
+		msg       amqp.Delivery
+		want      string
+	}{
+		{"Message id", "", amqp.Delivery{MessageId: "id"}, "id"},
+		{"Header", "x-key", amqp.Delivery{MessageId: "id", Headers: amqp.Table{"x-key": int64(42)}}, "42"},
+		{"Missing header", "x-key", amqp.Delivery{MessageId: "id"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &IdempotentHandler{KeyHeader: tt.keyHeader}
+			if got := handler.key(&tt.msg); got != tt.want {
+				t.Errorf("key() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_MemoryDedupStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := NewMemoryDedupStore(2, time.Minute)
+	store.now = func() time.Time { return now }
+
+	_ = store.MarkProcessed(ctx, "first")
+	_ = store.MarkProcessed(ctx, "second")
+	// first becomes the most recently used, second is evicted
+	if seen, _ := store.Seen(ctx, "first"); !seen {
+		t.Errorf("Seen(first) = false, want true")
+	}
+	_ = store.MarkProcessed(ctx, "third")
+
+	if seen, _ := store.Seen(ctx, "second"); seen {
+		t.Errorf("Seen(second) = true, least recently used key must be evicted")
+	}
+
+	now = now.Add(time.Minute)
+	if seen, _ := store.Seen(ctx, "third"); seen {
+		t.Errorf("Seen(third) = true, expired key must be ignored")
+	}
+
+	if store.Len() != 1 {
+		t.Errorf("Len() = %d, want 1", store.Len())
+	}
+}
+
+func Test_IdempotentHandler_AckWithError(t *testing.T) {
+	store := NewMemoryDedupStore(10, time.Minute)
+	calls := 0
+	// acks message and returns error like DelayedRetryMessageHandler after resend to delay queue
+	handler := NewIdempotentHandler(
+		MessageHandlerFunc(func(_ context.Context, _ *amqp.Channel, msg *amqp.Delivery) error {
+			calls++
+			_ = msg.Ack(false)
+			return errors.New("resent to delay queue")
+		}),
+		store,
+	)
+	handler.KeyHeader = "x-key"
+
+	msg := &amqp.Delivery{Acknowledger: &testAcknowledger{}, Headers: amqp.Table{"x-key": "key"}}
+	_ = handler.Handle(context.Background(), nil, msg)
+	_ = handler.Handle(context.Background(), nil, msg)
+
+	if calls != 2 {
+		t.Errorf("calls = %d, retried copy must be handled", calls)
+	}
+
+	if seen, _ := store.Seen(context.Background(), "key"); seen {
+		t.Errorf("Seen() = true, key of failed message must not be marked")
+	}
+}
@@ -0,0 +1,104 @@
+package rmq
+
+import (
+	"context"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+type (
+	// Middleware - MessageHandler decorator for cross-cutting concerns (logging, metrics, auth, etc.)
+	Middleware func(next MessageHandler) MessageHandler
+
+	// MessageHandlerFunc - func adapter for MessageHandler interface
+	MessageHandlerFunc func(ctx context.Context, channel *amqp.Channel, msg *amqp.Delivery) error
+
+	// MetricsObserver - receives handling result of every message, see MetricsMiddleware
+	MetricsObserver func(msg *amqp.Delivery, duration time.Duration, err error)
+)
+
+// Handle - MessageHandler implementation
+func (f MessageHandlerFunc) Handle(ctx context.Context, channel *amqp.Channel, msg *amqp.Delivery) error {
+	return f(ctx, channel, msg)
+}
+
+// Chain - wraps handler with middlewares, the first middleware is the outermost one
+func Chain(handler MessageHandler, middlewares ...Middleware) MessageHandler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+
+	return handler
+}
+
+// LoggingMiddleware - logs handling duration and error of every message, entry may be nil
+func LoggingMiddleware(entry *logrus.Entry) Middleware {
+	if entry == nil {
+		entry = logrus.NewEntry(logrus.StandardLogger())
+	}
+
+	return func(next MessageHandler) MessageHandler {
+		return MessageHandlerFunc(func(ctx context.Context, channel *amqp.Channel, msg *amqp.Delivery) error {
+			start := time.Now()
+			err := next.Handle(ctx, channel, msg)
+
+			logEntry := entry.WithFields(logrus.Fields{
+				"exchange":    msg.Exchange,
+				"routing_key": msg.RoutingKey,
+				"message_id":  msg.MessageId,
+				"duration":    time.Since(start),
+			})
+			if err != nil {
+				logEntry.WithError(err).Error("message handling failed")
+			} else {
+				logEntry.Debug("message is handled")
+			}
+
+			return err
+		})
+	}
+}
+
+// MetricsMiddleware - passes handling duration and error of every message to observer
+func MetricsMiddleware(observe MetricsObserver) Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return MessageHandlerFunc(func(ctx context.Context, channel *amqp.Channel, msg *amqp.Delivery) error {
+			start := time.Now()
+			err := next.Handle(ctx, channel, msg)
+			observe(msg, time.Since(start), err)
+
+			return err
+		})
+	}
+}
+
+// ContextMiddleware - enriches handler ctx from message, e.g. extracts tracing span from headers
+func ContextMiddleware(enrich func(ctx context.Context, msg *amqp.Delivery) context.Context) Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return MessageHandlerFunc(func(ctx context.Context, channel *amqp.Channel, msg *amqp.Delivery) error {
+			return next.Handle(enrich(ctx, msg), channel, msg)
+		})
+	}
+}
+
+// FilterMiddleware - passes to handler only accepted messages (auth, validation, etc.),
+// other messages are finished with action without handling
+func FilterMiddleware(accept func(ctx context.Context, msg *amqp.Delivery) bool, action MsgAction) Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return MessageHandlerFunc(func(ctx context.Context, channel *amqp.Channel, msg *amqp.Delivery) error {
+			if accept(ctx, msg) {
+				return next.Handle(ctx, channel, msg)
+			}
+
+			return doMsgAction(msg, action)
+		})
+	}
+}
+
+// DedupMiddleware - wraps handler with IdempotentHandler, keyHeader may be empty to deduplicate by MessageId
+func DedupMiddleware(store DedupStore, keyHeader string) Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return &IdempotentHandler{Handler: next, Store: store, KeyHeader: keyHeader}
+	}
+}
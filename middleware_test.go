@@ -0,0 +1,70 @@
+package rmq
+
+import (
+	"context"
+	"errors"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_Chain(t *testing.T) {
+	var calls []string
+	mw := func(name string) Middleware {
+		return func(next MessageHandler) MessageHandler {
+			return MessageHandlerFunc(func(ctx context.Context, channel *amqp.Channel, msg *amqp.Delivery) error {
+				calls = append(calls, name)
+				return next.Handle(ctx, channel, msg)
+			})
+		}
+	}
+
+	handler := Chain(MessageHandlerFunc(func(context.Context, *amqp.Channel, *amqp.Delivery) error {
+		calls = append(calls, "handler")
+		return nil
+	}), mw("first"), mw("second"))
+
+	_ = handler.Handle(context.Background(), nil, &amqp.Delivery{})
+	if want := []string{"first", "second", "handler"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+}
+
+func Test_FilterMiddleware(t *testing.T) {
+	handled := 0
+	handler := Chain(
+		MessageHandlerFunc(func(context.Context, *amqp.Channel, *amqp.Delivery) error {
+			handled++
+			return nil
+		}),
+		FilterMiddleware(func(_ context.Context, msg *amqp.Delivery) bool {
+			return msg.UserId == "admin"
+		}, ActionReject),
+	)
+
+	ack := &testAcknowledger{}
+	_ = handler.Handle(context.Background(), nil, &amqp.Delivery{Acknowledger: ack, UserId: "admin"})
+	_ = handler.Handle(context.Background(), nil, &amqp.Delivery{Acknowledger: ack, UserId: "guest"})
+
+	if handled != 1 || ack.rejects != 1 {
+		t.Errorf("handled = %d, rejects = %d, want 1, 1", handled, ack.rejects)
+	}
+}
+
+func Test_MetricsMiddleware(t *testing.T) {
+	handleErr := errors.New("failed")
+	var observed error
+	handler := Chain(
+		MessageHandlerFunc(func(context.Context, *amqp.Channel, *amqp.Delivery) error {
+			return handleErr
+		}),
+		MetricsMiddleware(func(_ *amqp.Delivery, _ time.Duration, err error) {
+			observed = err
+		}),
+	)
+
+	if err := handler.Handle(context.Background(), nil, &amqp.Delivery{}); err != handleErr || observed != handleErr {
+		t.Errorf("Handle() = %v, observed = %v, want %v", err, observed, handleErr)
+	}
+}
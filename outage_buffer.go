@@ -0,0 +1,255 @@
+package rmq
+
+import (
+	"context"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// OverflowPolicy - behaviour of full outage buffer
+type OverflowPolicy int
+
+const (
+	// OverflowBlock - publish waits for free space or ctx done
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest - the oldest buffered message is dropped
+	OverflowDropOldest
+	// OverflowFail - publish returns ErrBufferFull
+	OverflowFail
+)
+
+// ErrBufferFull - outage buffer is full or message is bigger than buffer, also set for dropped messages
+var ErrBufferFull = errors.New("publisher buffer is full")
+
+type (
+	// bufferEntry - buffered message with optional confirmation (PublishAsync)
+	bufferEntry struct {
+		msg     PublishMessage
+		confirm *PublishConfirmation
+		// onDone - called once, when entry is flushed or dropped
+		onDone func()
+	}
+
+	// outageBuffer - bounded FIFO of messages, which are waiting for connection recovery
+	outageBuffer struct {
+		cfg OutageBufferCfg
+		// mu - guards entries, bytes, taken and freed
+		mu      sync.Mutex
+		entries []*bufferEntry
+		bytes   int
+		// taken - count of entries, which are taken by flusher and not finished yet
+		taken int
+		// freed - closed and replaced when space is freed, wakes up blocked pushes
+		freed chan struct{}
+		// notify - wakes up flusher on new entries
+		notify chan struct{}
+	}
+)
+
+// newOutageBuffer - outageBuffer constructor
+func newOutageBuffer(cfg OutageBufferCfg) *outageBuffer {
+	return &outageBuffer{
+		cfg:    cfg,
+		freed:  make(chan struct{}),
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// push - appends entry to the end of buffer according to overflow policy
+func (ob *outageBuffer) push(ctx context.Context, entry *bufferEntry) error {
+	size := len(entry.msg.Publishing.Body)
+	if ob.cfg.MaxBytes > 0 && size > ob.cfg.MaxBytes {
+		return ErrBufferFull
+	}
+
+	for {
+		ob.mu.Lock()
+		if ob.fits(size) {
+			ob.entries = append(ob.entries, entry)
+			ob.bytes += size
+			ob.mu.Unlock()
+			ob.wakeUp()
+			return nil
+		}
+
+		switch ob.cfg.Overflow {
+		case OverflowFail:
+			ob.mu.Unlock()
+			return ErrBufferFull
+		case OverflowDropOldest:
+			dropped := ob.shift()
+			ob.mu.Unlock()
+			logrus.Warning("publisher buffer is full, the oldest message is dropped")
+			dropped.finish(ErrBufferFull)
+		default:
+			freed := ob.freed
+			ob.mu.Unlock()
+
+			select {
+			case <-freed:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// take - removes the oldest entry for flushing, entry must be passed to done or putBack after
+func (ob *outageBuffer) take() (*bufferEntry, bool) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	if len(ob.entries) == 0 {
+		return nil, false
+	}
+
+	ob.taken++
+	return ob.shift(), true
+}
+
+// putBack - returns not flushed entry to the head of buffer, limits are ignored
+func (ob *outageBuffer) putBack(entry *bufferEntry) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	ob.taken--
+	ob.entries = append([]*bufferEntry{entry}, ob.entries...)
+	ob.bytes += len(entry.msg.Publishing.Body)
+}
+
+// done - marks taken entry as finished
+func (ob *outageBuffer) done() {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	ob.taken--
+}
+
+// len - buffered entries count, including entries taken by flusher
+func (ob *outageBuffer) len() int {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	return len(ob.entries) + ob.taken
+}
+
+// fits - checks limits for a new entry, must be called under mu
+func (ob *outageBuffer) fits(size int) bool {
+	if ob.cfg.MaxMessages > 0 && len(ob.entries) >= ob.cfg.MaxMessages {
+		return false
+	}
+
+	return ob.cfg.MaxBytes <= 0 || ob.bytes+size <= ob.cfg.MaxBytes
+}
+
+// shift - removes the oldest entry and wakes up blocked pushes, must be called under mu
+func (ob *outageBuffer) shift() *bufferEntry {
+	entry := ob.entries[0]
+	ob.entries[0] = nil
+	ob.entries = ob.entries[1:]
+	ob.bytes -= len(entry.msg.Publishing.Body)
+
+	close(ob.freed)
+	ob.freed = make(chan struct{})
+
+	return entry
+}
+
+// wakeUp - non-blocking flusher notification
+func (ob *outageBuffer) wakeUp() {
+	select {
+	case ob.notify <- struct{}{}:
+	default:
+	}
+}
+
+// finish - resolves entry confirmation and runs onDone
+func (be *bufferEntry) finish(err error) {
+	if be.confirm != nil {
+		be.confirm.resolve(err)
+	}
+
+	if be.onDone != nil {
+		be.onDone()
+	}
+}
+
+// bufferMessage - puts message to the outage buffer, buffered message is counted as running publish
+func (p *Publisher) bufferMessage(ctx context.Context, msg *PublishMessage, confirm *PublishConfirmation) error {
+	p.inFlight.Add(1)
+	entry := &bufferEntry{msg: *msg, confirm: confirm, onDone: p.inFlight.Done}
+	if err := p.buffer.push(ctx, entry); err != nil {
+		p.inFlight.Done()
+		return err
+	}
+
+	// flusher is already stopped and won't finish this entry
+	if p.ctx.Err() != nil {
+		p.dropBuffer()
+	}
+
+	return nil
+}
+
+// shouldBuffer - message goes to buffer while connection is down or buffer is not empty (keeps order)
+func (p *Publisher) shouldBuffer() bool {
+	return p.buffer != nil && (p.connection.IsClosed() || p.buffer.len() > 0)
+}
+
+// flushBuffer - background task, publishes buffered messages in order when connection is ready.
+// Not flushed messages are finished with ErrPublisherClosed after publisher close
+func (p *Publisher) flushBuffer() {
+	defer p.dropBuffer()
+
+	for {
+		select {
+		case <-p.buffer.notify:
+		case <-p.ctx.Done():
+			return
+		}
+
+		for {
+			if err := p.connection.waitReady(p.ctx); err != nil {
+				return
+			}
+
+			entry, ok := p.buffer.take()
+			if !ok {
+				break
+			}
+
+			err := p.publishAndWait(p.ctx, &entry.msg)
+			if err != nil && IsRetryablePublishErr(err) {
+				p.buffer.putBack(entry)
+				logrus.WithError(err).Warning("unable to flush publisher buffer, retry in a second")
+				if sleepCtx(p.ctx, time.Second) != nil {
+					return
+				}
+
+				continue
+			}
+
+			if err != nil {
+				logrus.WithError(err).Error("buffered message is not published")
+			}
+
+			p.buffer.done()
+			entry.finish(err)
+		}
+	}
+}
+
+// dropBuffer - finishes all buffered entries with ErrPublisherClosed
+func (p *Publisher) dropBuffer() {
+	for {
+		entry, ok := p.buffer.take()
+		if !ok {
+			return
+		}
+
+		p.buffer.done()
+		entry.finish(ErrPublisherClosed)
+	}
+}
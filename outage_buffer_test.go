@@ -0,0 +1,137 @@
+package rmq
+
+import (
+	"context"
+	"errors"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"testing"
+	"time"
+)
+
+func newTestEntry(body string) *bufferEntry {
+	return &bufferEntry{
+		msg:     PublishMessage{RoutingKey: body, Publishing: amqp.Publishing{Body: []byte(body)}},
+		confirm: newPublishConfirmation(),
+	}
+}
+
+func Test_outageBuffer_OverflowFail(t *testing.T) {
+	buffer := newOutageBuffer(OutageBufferCfg{MaxMessages: 1, Overflow: OverflowFail})
+	if err := buffer.push(context.Background(), newTestEntry("first")); err != nil {
+		t.Fatalf("push() error = %v", err)
+	}
+
+	if err := buffer.push(context.Background(), newTestEntry("second")); !errors.Is(err, ErrBufferFull) {
+		t.Errorf("push() error = %v, want %v", err, ErrBufferFull)
+	}
+}
+
+func Test_outageBuffer_OverflowDropOldest(t *testing.T) {
+	buffer := newOutageBuffer(OutageBufferCfg{MaxBytes: 10, Overflow: OverflowDropOldest})
+	first, second, third := newTestEntry("12345"), newTestEntry("67890"), newTestEntry("abc")
+	for _, entry := range []*bufferEntry{first, second, third} {
+		if err := buffer.push(context.Background(), entry); err != nil {
+			t.Fatalf("push() error = %v", err)
+		}
+	}
+
+	if err := first.confirm.Wait(context.Background()); !errors.Is(err, ErrBufferFull) {
+		t.Errorf("dropped entry err = %v, want %v", err, ErrBufferFull)
+	}
+
+	for _, want := range []*bufferEntry{second, third} {
+		entry, ok := buffer.take()
+		if !ok || entry != want {
+			t.Fatalf("take() = %v, want %s", entry, want.msg.RoutingKey)
+		}
+		buffer.done()
+	}
+
+	if buffer.len() != 0 || buffer.bytes != 0 {
+		t.Errorf("buffer is not empty: len %d, bytes %d", buffer.len(), buffer.bytes)
+	}
+}
+
+func Test_outageBuffer_OverflowBlock(t *testing.T) {
+	buffer := newOutageBuffer(OutageBufferCfg{MaxMessages: 1})
+	if err := buffer.push(context.Background(), newTestEntry("first")); err != nil {
+		t.Fatalf("push() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	if err := buffer.push(ctx, newTestEntry("second")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("push() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	pushed := make(chan error)
+	go func() {
+		pushed <- buffer.push(context.Background(), newTestEntry("third"))
+	}()
+
+	if _, ok := buffer.take(); !ok {
+		t.Fatalf("take() from non-empty buffer failed")
+	}
+
+	if err := <-pushed; err != nil {
+		t.Errorf("blocked push() error = %v", err)
+	}
+}
+
+func Test_outageBuffer_putBack(t *testing.T) {
+	buffer := newOutageBuffer(OutageBufferCfg{})
+	first, second := newTestEntry("first"), newTestEntry("second")
+	_ = buffer.push(context.Background(), first)
+	_ = buffer.push(context.Background(), second)
+
+	entry, _ := buffer.take()
+	if buffer.len() != 2 {
+		t.Errorf("len() = %d, taken entry must be counted", buffer.len())
+	}
+
+	buffer.putBack(entry)
+	if entry, _ = buffer.take(); entry != first {
+		t.Errorf("take() after putBack = %s, want first", entry.msg.RoutingKey)
+	}
+}
+
+func Test_outageBuffer_TooBigMessage(t *testing.T) {
+	buffer := newOutageBuffer(OutageBufferCfg{MaxBytes: 3})
+	if err := buffer.push(context.Background(), newTestEntry("1234")); !errors.Is(err, ErrBufferFull) {
+		t.Errorf("push() error = %v, want %v", err, ErrBufferFull)
+	}
+}
+
+func Test_Publisher_flushBufferClose(t *testing.T) {
+	publisher := &Publisher{
+		connection: NewConnection(context.Background(), nil),
+		buffer:     newOutageBuffer(OutageBufferCfg{}),
+	}
+	publisher.ctx, publisher.done = context.WithCancel(context.Background())
+
+	confirm := newPublishConfirmation()
+	if err := publisher.bufferMessage(context.Background(), &PublishMessage{}, confirm); err != nil {
+		t.Fatalf("bufferMessage() error = %v", err)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		publisher.flushBuffer()
+		close(stopped)
+	}()
+	publisher.done()
+	<-stopped
+
+	if err := confirm.Wait(context.Background()); !errors.Is(err, ErrPublisherClosed) {
+		t.Errorf("confirmation err = %v, want %v", err, ErrPublisherClosed)
+	}
+
+	// running publishes counter is released by dropped entries
+	publisher.inFlight.Wait()
+
+	late := newPublishConfirmation()
+	_ = publisher.bufferMessage(context.Background(), &PublishMessage{}, late)
+	if err := late.Wait(context.Background()); !errors.Is(err, ErrPublisherClosed) {
+		t.Errorf("confirmation of message, buffered after close, err = %v, want %v", err, ErrPublisherClosed)
+	}
+}
@@ -0,0 +1,163 @@
+// Package outbox implements transactional outbox: messages are written to SQL table in the same transaction
+// with business data and are published to rmq by Relay after commit.
+//
+// Outbox table is created by user, e.g. for SQLite:
+//
+//	CREATE TABLE rmq_outbox (
+//		id            INTEGER PRIMARY KEY AUTOINCREMENT,
+//		exchange_name TEXT    NOT NULL,
+//		routing_key   TEXT    NOT NULL,
+//		mandatory     BOOLEAN NOT NULL,
+//		immediate     BOOLEAN NOT NULL,
+//		publishing    BLOB    NOT NULL,
+//		created_at    BIGINT  NOT NULL,
+//		sent_at       BIGINT  NOT NULL DEFAULT 0,
+//		lease         TEXT    NOT NULL DEFAULT '',
+//		lease_until   BIGINT  NOT NULL DEFAULT 0,
+//		attempts      INTEGER NOT NULL DEFAULT 0
+//	);
+//
+// For PostgreSQL use BIGSERIAL id and BYTEA publishing with DollarPlaceholder,
+// for MySQL use BIGINT AUTO_INCREMENT id, VARCHAR lease and LONGBLOB publishing.
+// Timestamps are stored as unix milliseconds.
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/Maximilan4/rmq"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"strings"
+	"time"
+)
+
+// ErrEmptyMessage - nil message was passed to Add
+var ErrEmptyMessage = errors.New("outbox message is empty")
+
+type (
+	// Placeholder - returns bind parameter placeholder by its number, starting from 1
+	Placeholder func(n int) string
+
+	// Config - outbox table settings, shared by Store and Relay
+	Config struct {
+		// Table - outbox table name, default is rmq_outbox
+		Table string
+		// Placeholder - bind parameter style of sql driver, default is QuestionPlaceholder
+		Placeholder Placeholder
+	}
+
+	// Store - writes messages to outbox table
+	Store struct {
+		insertQuery string
+	}
+)
+
+// QuestionPlaceholder - ? placeholders (SQLite, MySQL)
+func QuestionPlaceholder(int) string {
+	return "?"
+}
+
+// DollarPlaceholder - $n placeholders (PostgreSQL)
+func DollarPlaceholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+// NewStore - Store constructor, cfg may be nil
+func NewStore(cfg *Config) *Store {
+	c := withDefaults(cfg)
+	return &Store{
+		insertQuery: c.query(
+			`INSERT INTO %s (exchange_name, routing_key, mandatory, immediate, publishing, created_at)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+		),
+	}
+}
+
+// Add - writes message to outbox in the caller transaction, message is published by Relay after commit
+func (s *Store) Add(ctx context.Context, tx *sql.Tx, msg *rmq.PublishMessage) error {
+	if msg == nil {
+		return ErrEmptyMessage
+	}
+
+	publishing, err := encodePublishing(&msg.Publishing)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		s.insertQuery,
+		msg.ExchangeName,
+		msg.RoutingKey,
+		msg.Mandatory,
+		msg.Immediate,
+		publishing,
+		time.Now().UnixMilli(),
+	)
+	if err != nil {
+		return fmt.Errorf("outbox insert error: %w", err)
+	}
+
+	return nil
+}
+
+// withDefaults - copies cfg and fills default values
+func withDefaults(cfg *Config) Config {
+	var c Config
+	if cfg != nil {
+		c = *cfg
+	}
+
+	if c.Table == "" {
+		c.Table = "rmq_outbox"
+	}
+
+	if c.Placeholder == nil {
+		c.Placeholder = QuestionPlaceholder
+	}
+
+	return c
+}
+
+// query - puts table name to query and replaces ? with configured placeholders
+func (c Config) query(query string) string {
+	query = fmt.Sprintf(query, c.Table)
+
+	var builder strings.Builder
+	n := 0
+	for _, r := range query {
+		if r != '?' {
+			builder.WriteRune(r)
+			continue
+		}
+
+		n++
+		builder.WriteString(c.Placeholder(n))
+	}
+
+	return builder.String()
+}
+
+// encodePublishing - gob encoding of publishing, header types are registered by rmq package
+func encodePublishing(publishing *amqp.Publishing) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(publishing); err != nil {
+		return nil, fmt.Errorf("outbox message encode error: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decodePublishing - encodePublishing reverse
+func decodePublishing(data []byte) (amqp.Publishing, error) {
+	var publishing amqp.Publishing
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&publishing); err != nil {
+		return publishing, fmt.Errorf("outbox message decode error: %w", err)
+	}
+
+	return publishing, nil
+}
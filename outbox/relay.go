@@ -0,0 +1,281 @@
+package outbox
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/Maximilan4/rmq"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+type (
+	// Publisher - message publisher, *rmq.Publisher in confirm mode is expected,
+	// so nil error means the message is accepted by broker
+	Publisher interface {
+		Publish(ctx context.Context, msg *rmq.PublishMessage) error
+	}
+
+	// RelayCfg - Relay settings
+	RelayCfg struct {
+		Config
+		// BatchSize - max messages count, leased by a single poll, default is 100
+		BatchSize int
+		// PollInterval - delay between polls of empty table, default is 1s
+		PollInterval time.Duration
+		// LeaseTime - time of exclusive batch ownership, other relays pick up the batch after lease expiration,
+		// default is 30s. Must be greater than batch publishing time
+		LeaseTime time.Duration
+		// DeleteSent - delete published rows instead of marking them with sent_at
+		DeleteSent bool
+		// MaxAttempts - failed publish attempts, after which message is parked: it stays in table with
+		// attempts >= MaxAttempts and is not leased anymore, so it doesn't block next messages. Default is 10.
+		// Connection errors (see rmq.IsRetryablePublishErr) and relay stop are not counted as attempts.
+		// Messages, which can't be decoded, are parked immediately. Reset attempts to publish parked message again
+		MaxAttempts int
+	}
+
+	// Relay - polls outbox table and publishes messages. Several relays may work with the same table:
+	// rows are leased by batches, so every message is published by a single relay at a time.
+	// Delivery is at least once: message is published again if relay dies before marking it as sent
+	Relay struct {
+		db        *sql.DB
+		publisher Publisher
+		cfg       RelayCfg
+
+		leaseQuery, selectQuery, sentQuery, failQuery, parkQuery, releaseQuery string
+	}
+
+	// outboxRow - leased outbox message
+	outboxRow struct {
+		id       int64
+		attempts int
+		msg      rmq.PublishMessage
+	}
+)
+
+// NewRelay - Relay constructor, cfg may be nil
+func NewRelay(db *sql.DB, publisher Publisher, cfg *RelayCfg) *Relay {
+	relay := &Relay{db: db, publisher: publisher}
+	if cfg != nil {
+		relay.cfg = *cfg
+	}
+
+	relay.cfg.Config = withDefaults(&relay.cfg.Config)
+
+	if relay.cfg.BatchSize == 0 {
+		relay.cfg.BatchSize = 100
+	}
+
+	if relay.cfg.PollInterval == 0 {
+		relay.cfg.PollInterval = time.Second
+	}
+
+	if relay.cfg.LeaseTime == 0 {
+		relay.cfg.LeaseTime = time.Second * 30
+	}
+
+	if relay.cfg.MaxAttempts == 0 {
+		relay.cfg.MaxAttempts = 10
+	}
+
+	c := relay.cfg.Config
+	// lease conditions are repeated in the outer query, so concurrent relays can't lease the same row.
+	// Derived table is required by MySQL, which doesn't support LIMIT in IN subquery and subquery on updated table
+	relay.leaseQuery = c.query(`UPDATE %[1]s SET lease = ?, lease_until = ?
+		WHERE sent_at = 0 AND lease_until < ? AND attempts < ? AND id IN (
+			SELECT id FROM (
+				SELECT id FROM %[1]s WHERE sent_at = 0 AND lease_until < ? AND attempts < ? ORDER BY id LIMIT ?
+			) AS batch
+		)`)
+	relay.selectQuery = c.query(`SELECT id, attempts, exchange_name, routing_key, mandatory, immediate, publishing
+		FROM %s WHERE lease = ? AND sent_at = 0 ORDER BY id`)
+	if relay.cfg.DeleteSent {
+		relay.sentQuery = c.query(`DELETE FROM %s WHERE id = ? AND lease = ?`)
+	} else {
+		relay.sentQuery = c.query(`UPDATE %s SET sent_at = ?, lease = '', lease_until = 0 WHERE id = ? AND lease = ?`)
+	}
+	relay.failQuery = c.query(`UPDATE %s SET attempts = attempts + 1, lease = '', lease_until = 0
+		WHERE id = ? AND lease = ?`)
+	relay.parkQuery = c.query(`UPDATE %s SET attempts = ?, lease = '', lease_until = 0 WHERE id = ? AND lease = ?`)
+	relay.releaseQuery = c.query(`UPDATE %s SET lease = '', lease_until = 0 WHERE lease = ? AND sent_at = 0`)
+
+	return relay
+}
+
+// Run - relays messages until ctx is done
+func (r *Relay) Run(ctx context.Context) error {
+	for {
+		count, err := r.RelayBatch(ctx)
+		if err != nil && ctx.Err() == nil {
+			logrus.WithError(err).Error("outbox relay error")
+		}
+
+		// full batch means there are more messages in table
+		if err == nil && count == r.cfg.BatchSize {
+			continue
+		}
+
+		select {
+		case <-time.After(r.cfg.PollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// RelayBatch - leases a single batch and publishes it in order, returns published messages count.
+// Publishing is stopped on the first error, the rest of batch is released for the next poll
+func (r *Relay) RelayBatch(ctx context.Context) (int, error) {
+	lease, err := newLease()
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := r.leaseBatch(ctx, lease)
+	if err != nil || len(rows) == 0 {
+		return 0, err
+	}
+
+	for num, row := range rows {
+		if err = r.publisher.Publish(ctx, &row.msg); err != nil {
+			r.fail(ctx, row, lease, len(rows)-num, err)
+			return num, fmt.Errorf("outbox message %d publish error: %w", row.id, err)
+		}
+
+		// ctx is not used: published message must be marked even if relay is stopping
+		if err = r.markSent(row.id, lease); err != nil {
+			return num, fmt.Errorf("outbox message %d mark error: %w", row.id, err)
+		}
+	}
+
+	return len(rows), nil
+}
+
+// leaseBatch - leases and reads the oldest not sent messages, parks messages, which can't be decoded
+func (r *Relay) leaseBatch(ctx context.Context, lease string) ([]outboxRow, error) {
+	now := time.Now()
+	_, err := r.db.ExecContext(
+		ctx,
+		r.leaseQuery,
+		lease,
+		now.Add(r.cfg.LeaseTime).UnixMilli(),
+		now.UnixMilli(),
+		r.cfg.MaxAttempts,
+		now.UnixMilli(),
+		r.cfg.MaxAttempts,
+		r.cfg.BatchSize,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("outbox lease error: %w", err)
+	}
+
+	result, err := r.db.QueryContext(ctx, r.selectQuery, lease)
+	if err != nil {
+		return nil, fmt.Errorf("outbox select error: %w", err)
+	}
+	defer result.Close()
+
+	var (
+		rows   []outboxRow
+		broken []int64
+	)
+	for result.Next() {
+		var (
+			row        outboxRow
+			publishing []byte
+		)
+
+		err = result.Scan(
+			&row.id,
+			&row.attempts,
+			&row.msg.ExchangeName,
+			&row.msg.RoutingKey,
+			&row.msg.Mandatory,
+			&row.msg.Immediate,
+			&publishing,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("outbox scan error: %w", err)
+		}
+
+		if row.msg.Publishing, err = decodePublishing(publishing); err != nil {
+			logrus.WithError(err).WithField("id", row.id).Error("outbox message is parked")
+			broken = append(broken, row.id)
+			continue
+		}
+
+		rows = append(rows, row)
+	}
+
+	if err = result.Err(); err != nil {
+		return nil, fmt.Errorf("outbox select error: %w", err)
+	}
+
+	// rows are updated after result closing, some drivers don't allow queries on busy connection
+	result.Close()
+	for _, id := range broken {
+		if _, err = r.db.ExecContext(ctx, r.parkQuery, r.cfg.MaxAttempts, id, lease); err != nil {
+			return nil, fmt.Errorf("outbox message %d park error: %w", id, err)
+		}
+	}
+
+	return rows, nil
+}
+
+// markSent - marks published message as sent or deletes it
+func (r *Relay) markSent(id int64, lease string) (err error) {
+	if r.cfg.DeleteSent {
+		_, err = r.db.Exec(r.sentQuery, id, lease)
+	} else {
+		_, err = r.db.Exec(r.sentQuery, time.Now().UnixMilli(), id, lease)
+	}
+
+	return
+}
+
+// fail - increments attempts of failed message and releases the rest of batch.
+// Broker outage and relay stop are not message failures, so batch is released without attempts increment
+func (r *Relay) fail(ctx context.Context, row outboxRow, lease string, left int, publishErr error) {
+	if ctx.Err() != nil || isCtxErr(publishErr) || rmq.IsRetryablePublishErr(publishErr) {
+		if _, err := r.db.Exec(r.releaseQuery, lease); err != nil {
+			logrus.WithError(err).Error("outbox lease release error")
+		}
+
+		return
+	}
+
+	if _, err := r.db.Exec(r.failQuery, row.id, lease); err != nil {
+		logrus.WithError(err).Error("outbox fail mark error")
+	}
+
+	if row.attempts+1 >= r.cfg.MaxAttempts {
+		logrus.WithField("id", row.id).Errorf("outbox message is parked after %d attempts", row.attempts+1)
+	}
+
+	if left <= 1 {
+		return
+	}
+
+	if _, err := r.db.Exec(r.releaseQuery, lease); err != nil {
+		logrus.WithError(err).Error("outbox lease release error")
+	}
+}
+
+// isCtxErr - error is caused by ctx cancel or deadline
+func isCtxErr(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// newLease - random lease token
+func newLease() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("outbox lease generate error: %w", err)
+	}
+
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,271 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/Maximilan4/rmq"
+	_ "github.com/mattn/go-sqlite3"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+const testSchema = `CREATE TABLE rmq_outbox (
+	id            INTEGER PRIMARY KEY AUTOINCREMENT,
+	exchange_name TEXT    NOT NULL,
+	routing_key   TEXT    NOT NULL,
+	mandatory     BOOLEAN NOT NULL,
+	immediate     BOOLEAN NOT NULL,
+	publishing    BLOB    NOT NULL,
+	created_at    BIGINT  NOT NULL,
+	sent_at       BIGINT  NOT NULL DEFAULT 0,
+	lease         TEXT    NOT NULL DEFAULT '',
+	lease_until   BIGINT  NOT NULL DEFAULT 0,
+	attempts      INTEGER NOT NULL DEFAULT 0
+)`
+
+type testPublisher struct {
+	mu       sync.Mutex
+	messages []rmq.PublishMessage
+	failOn   string
+	// err - error of failed publish, default is not retryable error
+	err error
+}
+
+func (tp *testPublisher) Publish(_ context.Context, msg *rmq.PublishMessage) error {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+
+	if msg.RoutingKey == tp.failOn && tp.err != nil {
+		return tp.err
+	}
+
+	if msg.RoutingKey == tp.failOn {
+		return errors.New("publish failed")
+	}
+
+	tp.messages = append(tp.messages, *msg)
+	return nil
+}
+
+func openTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "outbox.db")+"?_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err = db.Exec(testSchema); err != nil {
+		t.Fatalf("schema create error = %v", err)
+	}
+
+	return db
+}
+
+func addMessages(t *testing.T, db *sql.DB, keys ...string) {
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+
+	store := NewStore(nil)
+	for _, key := range keys {
+		err = store.Add(context.Background(), tx, &rmq.PublishMessage{
+			ExchangeName: "exchange",
+			RoutingKey:   key,
+			Publishing:   amqp.Publishing{Body: []byte(key), Headers: amqp.Table{"key": key}},
+		})
+		if err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+}
+
+func Test_Relay_RelayBatch(t *testing.T) {
+	db := openTestDB(t)
+	addMessages(t, db, "first", "second", "third")
+
+	publisher := &testPublisher{}
+	relay := NewRelay(db, publisher, &RelayCfg{BatchSize: 2})
+
+	for _, want := range []int{2, 1, 0} {
+		count, err := relay.RelayBatch(context.Background())
+		if err != nil || count != want {
+			t.Fatalf("RelayBatch() = %d, %v, want %d", count, err, want)
+		}
+	}
+
+	for num, key := range []string{"first", "second", "third"} {
+		msg := publisher.messages[num]
+		if msg.RoutingKey != key || string(msg.Publishing.Body) != key || msg.Publishing.Headers["key"] != key {
+			t.Errorf("message %d = %+v, want %s", num, msg, key)
+		}
+	}
+
+	var pending int
+	_ = db.QueryRow("SELECT COUNT(*) FROM rmq_outbox WHERE sent_at = 0").Scan(&pending)
+	if pending != 0 {
+		t.Errorf("pending messages = %d, want 0", pending)
+	}
+}
+
+func Test_Relay_RolledBackTx(t *testing.T) {
+	db := openTestDB(t)
+	tx, _ := db.Begin()
+	_ = NewStore(nil).Add(context.Background(), tx, &rmq.PublishMessage{RoutingKey: "rolled back"})
+	_ = tx.Rollback()
+
+	count, err := NewRelay(db, &testPublisher{}, nil).RelayBatch(context.Background())
+	if err != nil || count != 0 {
+		t.Errorf("RelayBatch() = %d, %v, want 0", count, err)
+	}
+}
+
+func Test_Relay_PublishError(t *testing.T) {
+	db := openTestDB(t)
+	addMessages(t, db, "first", "second", "third")
+
+	publisher := &testPublisher{failOn: "second"}
+	relay := NewRelay(db, publisher, &RelayCfg{DeleteSent: true})
+
+	count, err := relay.RelayBatch(context.Background())
+	if err == nil || count != 1 {
+		t.Fatalf("RelayBatch() = %d, %v, want 1 and error", count, err)
+	}
+
+	var attempts int
+	_ = db.QueryRow("SELECT attempts FROM rmq_outbox WHERE routing_key = 'second'").Scan(&attempts)
+	if attempts != 1 {
+		t.Errorf("attempts = %d, want 1", attempts)
+	}
+
+	// failed message and the rest of batch are available for the next poll
+	publisher.failOn = ""
+	if count, err = relay.RelayBatch(context.Background()); err != nil || count != 2 {
+		t.Fatalf("RelayBatch() = %d, %v, want 2", count, err)
+	}
+
+	var rows int
+	_ = db.QueryRow("SELECT COUNT(*) FROM rmq_outbox").Scan(&rows)
+	if rows != 0 {
+		t.Errorf("rows = %d, sent rows must be deleted", rows)
+	}
+}
+
+func Test_Relay_ConcurrentRelays(t *testing.T) {
+	db := openTestDB(t)
+	keys := make([]string, 50)
+	for num := range keys {
+		keys[num] = string(rune('a'+num%26)) + string(rune('a'+num/26))
+	}
+	addMessages(t, db, keys...)
+
+	publisher := &testPublisher{}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			relay := NewRelay(db, publisher, &RelayCfg{BatchSize: 5})
+			for {
+				count, err := relay.RelayBatch(context.Background())
+				if err != nil {
+					t.Errorf("RelayBatch() error = %v", err)
+					return
+				}
+
+				if count == 0 {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	seen := make(map[string]int)
+	for _, msg := range publisher.messages {
+		seen[msg.RoutingKey]++
+	}
+
+	if len(publisher.messages) != len(keys) || len(seen) != len(keys) {
+		t.Errorf("published %d messages, %d unique, want %d", len(publisher.messages), len(seen), len(keys))
+	}
+}
+
+func Test_Relay_MaxAttempts(t *testing.T) {
+	db := openTestDB(t)
+	addMessages(t, db, "poison", "second")
+
+	publisher := &testPublisher{failOn: "poison"}
+	relay := NewRelay(db, publisher, &RelayCfg{MaxAttempts: 2})
+
+	for i := 0; i < 2; i++ {
+		if _, err := relay.RelayBatch(context.Background()); err == nil {
+			t.Fatalf("RelayBatch() error = nil, want poison message error")
+		}
+	}
+
+	// poison message is parked and doesn't block the next one
+	count, err := relay.RelayBatch(context.Background())
+	if err != nil || count != 1 || publisher.messages[0].RoutingKey != "second" {
+		t.Fatalf("RelayBatch() = %d, %v, want second message published", count, err)
+	}
+
+	var attempts, sentAt int
+	_ = db.QueryRow("SELECT attempts, sent_at FROM rmq_outbox WHERE routing_key = 'poison'").Scan(&attempts, &sentAt)
+	if attempts != 2 || sentAt != 0 {
+		t.Errorf("parked message attempts = %d, sent_at = %d, want 2, 0", attempts, sentAt)
+	}
+}
+
+func Test_Relay_ConnectionNotReady(t *testing.T) {
+	db := openTestDB(t)
+	addMessages(t, db, "first", "second")
+
+	publisher := &testPublisher{failOn: "first", err: rmq.ErrConnectionNotReady}
+	relay := NewRelay(db, publisher, &RelayCfg{MaxAttempts: 2})
+
+	// outage longer than MaxAttempts polls doesn't park messages
+	for i := 0; i < 3; i++ {
+		if count, err := relay.RelayBatch(context.Background()); !errors.Is(err, rmq.ErrConnectionNotReady) || count != 0 {
+			t.Fatalf("RelayBatch() = %d, %v, want %v", count, err, rmq.ErrConnectionNotReady)
+		}
+	}
+
+	var attempts, leased int
+	_ = db.QueryRow("SELECT SUM(attempts), SUM(lease <> '') FROM rmq_outbox").Scan(&attempts, &leased)
+	if attempts != 0 || leased != 0 {
+		t.Errorf("attempts = %d, leased rows = %d, want 0, 0", attempts, leased)
+	}
+
+	publisher.failOn = ""
+	if count, err := relay.RelayBatch(context.Background()); err != nil || count != 2 {
+		t.Fatalf("RelayBatch() = %d, %v, want 2", count, err)
+	}
+}
+
+func Test_Relay_DecodeError(t *testing.T) {
+	db := openTestDB(t)
+	addMessages(t, db, "first", "broken", "third")
+	if _, err := db.Exec("UPDATE rmq_outbox SET publishing = x'00' WHERE routing_key = 'broken'"); err != nil {
+		t.Fatalf("update error = %v", err)
+	}
+
+	publisher := &testPublisher{}
+	count, err := NewRelay(db, publisher, &RelayCfg{MaxAttempts: 3}).RelayBatch(context.Background())
+	if err != nil || count != 2 {
+		t.Fatalf("RelayBatch() = %d, %v, want 2", count, err)
+	}
+
+	var attempts int
+	_ = db.QueryRow("SELECT attempts FROM rmq_outbox WHERE routing_key = 'broken'").Scan(&attempts)
+	if attempts != 3 {
+		t.Errorf("broken message attempts = %d, it must be parked", attempts)
+	}
+}
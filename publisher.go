@@ -3,12 +3,17 @@ package rmq
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/jackc/puddle"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/sirupsen/logrus"
+	"sync"
 	"time"
 )
 
+// ErrPublisherClosed - publisher is closed or shutting down and doesn't accept new messages
+var ErrPublisherClosed = errors.New("publisher is closed")
+
 // PublishMessage - struct with params from amqp.Channel().Publish(...) method
 type PublishMessage struct {
 	ExchangeName, RoutingKey string
@@ -23,6 +28,15 @@ type Publisher struct {
 	cfg        PublisherConfig
 	ctx        context.Context
 	done       context.CancelFunc
+	// mu - guards closed flag and inFlight wait group
+	mu     sync.RWMutex
+	closed bool
+	// inFlight - running Publish calls
+	inFlight sync.WaitGroup
+	// closeOnce - pool closing guard for Close and Shutdown
+	closeOnce sync.Once
+	// buffer - outage buffer, nil if PublisherConfig.Buffer is not set
+	buffer *outageBuffer
 }
 
 // NewPublisher - publisher constructor
@@ -46,6 +60,23 @@ func NewPublisher(connection *Connection, cfg *PublisherConfig) *Publisher {
 		publisher.cfg.CleanUpInterval = time.Minute
 	}
 
+	if cfg.Retry != nil {
+		retry := *cfg.Retry
+		if retry.Backoff == nil {
+			retry.Backoff = &ExponentialBackoff{MaxAttempts: 5}
+		}
+
+		if retry.Retryable == nil {
+			retry.Retryable = IsRetryablePublishErr
+		}
+
+		publisher.cfg.Retry = &retry
+	}
+
+	if cfg.Buffer != nil {
+		publisher.buffer = newOutageBuffer(*cfg.Buffer)
+	}
+
 	return publisher
 }
 
@@ -60,6 +91,14 @@ func (p *Publisher) Init() error {
 	}
 
 	go p.background()
+	if p.buffer != nil {
+		go p.flushBuffer()
+	}
+
+	if p.cfg.Spool != nil {
+		go p.replaySpool()
+	}
+
 	return nil
 }
 
@@ -68,35 +107,248 @@ func (p *Publisher) Pool() *puddle.Pool {
 	return p.pool
 }
 
-// Close - closes active connection and channels pool
+// Close - closes channels pool immediately, running publishes may fail. See Shutdown for graceful stop
 func (p *Publisher) Close() {
-	p.pool.Close()
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+
+	p.closePool()
 	p.done()
 }
 
-// Publish - publish a message to exchange
+// Shutdown - graceful publisher stop: rejects new publishes with ErrPublisherClosed, waits for running publishes
+// (including pending confirmations of PublishAsync) and closes channels pool. If ctx is done earlier,
+// publisher context is cancelled and ctx error is returned, pool will be closed after running publishes
+func (p *Publisher) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		p.inFlight.Wait()
+		p.closePool()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		p.done()
+		logrus.Info("publisher is shut down")
+		return nil
+	case <-ctx.Done():
+		p.done()
+		return fmt.Errorf("publisher shutdown: %w", ctx.Err())
+	}
+}
+
+// closePool - closes channels pool once
+func (p *Publisher) closePool() {
+	p.closeOnce.Do(p.pool.Close)
+}
+
+// Publish - publish a message to exchange.
+// In confirm mode (PublisherConfig.ConfirmMode) blocks until broker ack/nack or ctx is done,
+// returns ErrPublishNacked on nack and UnroutableError if mandatory message was returned by broker.
+// If PublisherConfig.Spool or PublisherConfig.Buffer is set, message is stored while connection is down
+// and nil is returned. Nacked messages are stored to spool too
 func (p *Publisher) Publish(ctx context.Context, msg *PublishMessage) error {
+	if !p.startPublish() {
+		return ErrPublisherClosed
+	}
+	defer p.inFlight.Done()
+
+	if p.shouldSpool() {
+		return p.spoolMessage(msg)
+	}
+
+	if p.shouldBuffer() {
+		return p.bufferMessage(ctx, msg, nil)
+	}
+
+	err := p.withRetry(ctx, func() error {
+		return p.publishAndWait(ctx, msg)
+	})
+
+	if err != nil && p.cfg.Spool != nil && (IsRetryablePublishErr(err) || errors.Is(err, ErrPublishNacked)) {
+		return p.spoolMessage(msg)
+	}
+
+	if err != nil && p.buffer != nil && IsRetryablePublishErr(err) {
+		return p.bufferMessage(ctx, msg, nil)
+	}
+
+	return err
+}
+
+// PublishAsync - publish a message to exchange without waiting for broker confirmation.
+// Returned PublishConfirmation is resolved on broker ack/nack in confirm mode, or immediately otherwise.
+// If PublisherConfig.Spool is set, messages failed by connection errors or nacked by broker are spooled,
+// and confirmation is resolved with nil after spooling. Shutdown waits for all returned confirmations
+func (p *Publisher) PublishAsync(ctx context.Context, msg *PublishMessage) (*PublishConfirmation, error) {
+	if !p.startPublish() {
+		return nil, ErrPublisherClosed
+	}
+
+	// spooled message is durable, so its confirmation is resolved immediately
+	if p.shouldSpool() {
+		defer p.inFlight.Done()
+		if err := p.spoolMessage(msg); err != nil {
+			return nil, err
+		}
+
+		confirm := newPublishConfirmation()
+		confirm.resolve(nil)
+		return confirm, nil
+	}
+
+	if p.shouldBuffer() {
+		defer p.inFlight.Done()
+		confirm := newPublishConfirmation()
+		if err := p.bufferMessage(ctx, msg, confirm); err != nil {
+			return nil, err
+		}
+
+		return confirm, nil
+	}
+
+	var confirm *PublishConfirmation
+	err := p.withRetry(ctx, func() (err error) {
+		confirm, err = p.publish(ctx, msg)
+		return
+	})
+
+	if err != nil && p.cfg.Spool != nil && IsRetryablePublishErr(err) {
+		err = p.spoolMessage(msg)
+		if err == nil {
+			confirm = nil
+		}
+	}
+
+	if err != nil {
+		p.inFlight.Done()
+		return nil, err
+	}
+
+	if confirm == nil {
+		confirm = newPublishConfirmation()
+		confirm.resolve(nil)
+	}
+
+	if p.cfg.Spool != nil {
+		confirm = p.spoolNacked(msg, confirm)
+	}
+
+	confirm.OnDone(func(error) {
+		p.inFlight.Done()
+	})
+
+	return confirm, nil
+}
+
+// spoolNacked - spools message if broker nacks it, returned confirmation is resolved after spooling
+// with nil or spool error, other results are passed as is
+func (p *Publisher) spoolNacked(msg *PublishMessage, confirm *PublishConfirmation) *PublishConfirmation {
+	spooled := *msg
+	result := newPublishConfirmation()
+	confirm.OnDone(func(err error) {
+		if errors.Is(err, ErrPublishNacked) {
+			err = p.spoolMessage(&spooled)
+		}
+
+		result.resolve(err)
+	})
+
+	return result
+}
+
+// PublishWithCallback - callback variant of PublishAsync, callback is called once after broker ack/nack
+func (p *Publisher) PublishWithCallback(ctx context.Context, msg *PublishMessage, callback func(err error)) error {
+	confirm, err := p.PublishAsync(ctx, msg)
+	if err != nil {
+		return err
+	}
+
+	confirm.OnDone(callback)
+	return nil
+}
+
+// IsRetryablePublishErr - default PublishRetryPolicy.Retryable: lost connection or closed channel
+func IsRetryablePublishErr(err error) bool {
+	return errors.Is(err, ErrConnectionNotReady) || errors.Is(err, amqp.ErrClosed)
+}
+
+// withRetry - runs publish func with PublisherConfig.Retry policy, waits for connection recovery between attempts
+func (p *Publisher) withRetry(ctx context.Context, publish func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := publish()
+		if err == nil || p.cfg.Retry == nil || ctx.Err() != nil || !p.cfg.Retry.Retryable(err) {
+			return err
+		}
+
+		delay, ok := p.cfg.Retry.Backoff.Delay(attempt)
+		if !ok {
+			return fmt.Errorf("publish failed after %d attempts: %w", attempt, err)
+		}
+
+		logrus.WithError(err).Warningf("publish failed, retry %d in %s", attempt, delay)
+		if sErr := sleepCtx(ctx, delay); sErr != nil {
+			return err
+		}
+
+		if wErr := p.connection.waitReady(ctx); wErr != nil {
+			return err
+		}
+	}
+}
+
+// startPublish - registers publish in inFlight, returns false if publisher is closed
+func (p *Publisher) startPublish() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return false
+	}
+
+	p.inFlight.Add(1)
+	return true
+}
+
+// publishAndWait - publishes message and waits for confirmation in confirm mode
+func (p *Publisher) publishAndWait(ctx context.Context, msg *PublishMessage) error {
+	confirm, err := p.publish(ctx, msg)
+	if err != nil || confirm == nil {
+		return err
+	}
+
+	return confirm.Wait(ctx)
+}
+
+// publish - publishes message on a pooled channel, returns confirmation in confirm mode
+func (p *Publisher) publish(ctx context.Context, msg *PublishMessage) (*PublishConfirmation, error) {
 	if p.connection.IsClosed() {
-		return errors.New("connection is not ready")
+		return nil, ErrConnectionNotReady
 	}
 
 	resource, err := p.pool.Acquire(ctx)
 	if err != nil {
-		resource.Destroy()
-		return err
+		return nil, err
 	}
 
-	channel := resource.Value().(*amqp.Channel)
+	channel := resource.Value().(*publisherChannel)
 
-	err = channel.Publish(msg.ExchangeName, msg.RoutingKey, msg.Mandatory, msg.Immediate, msg.Publishing)
+	confirm, err := channel.publish(msg)
 	if err != nil {
 		resource.Destroy()
-		return err
+		return nil, err
 	}
 
+	// channel is released before confirm waiting, so other publishes may use it meanwhile
 	resource.Release()
 
-	return nil
+	return confirm, nil
 }
 
 // chanClose - channel destruction
@@ -105,7 +357,7 @@ func (p *Publisher) chanClose(channel interface{}) {
 		return
 	}
 
-	err := channel.(*amqp.Channel).Close()
+	err := channel.(*publisherChannel).channel.Close()
 	if err != nil {
 		logrus.Errorf("error while rmq channel close: %s", err)
 		return
@@ -113,39 +365,66 @@ func (p *Publisher) chanClose(channel interface{}) {
 	logrus.Infof("rmq channel closed")
 }
 
-// chanInit - channel construction
+// chanInit - channel construction, retries with connection backoff until ctx is done or attempts are exhausted
 func (p *Publisher) chanInit(ctx context.Context) (interface{}, error) {
-	var channel *amqp.Channel
-	var err error
-	for {
-		select {
-		case <-ctx.Done():
-			return nil, ctx.Err()
-		default:
-			channel, err = p.connection.Channel()
-			if err != nil {
-				logrus.WithField("err", err).Warningf("unable to init rmq channel")
-				continue
-			}
+	for attempt := 1; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 
+		pubChannel, err := p.openChannel()
+		if err == nil {
 			logrus.Infof("rmq channel inited")
-			return channel, nil
+			return pubChannel, nil
+		}
+
+		// pauses are taken from connection backoff, so channel opening doesn't spin while reconnect is in progress
+		delay, ok := p.connection.cfg.Backoff.Delay(attempt)
+		if !ok {
+			return nil, fmt.Errorf("unable to init rmq channel after %d attempts: %w", attempt, err)
 		}
+
+		logrus.WithField("err", err).Warningf("unable to init rmq channel, retry %d in %s", attempt, delay)
+		if err = sleepCtx(ctx, delay); err != nil {
+			return nil, err
+		}
+
+		if err = p.connection.waitReady(ctx); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// openChannel - opens a new channel and puts it in confirm mode if required
+func (p *Publisher) openChannel() (*publisherChannel, error) {
+	channel, err := p.connection.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	pubChannel, err := newPublisherChannel(channel, p.cfg.ConfirmMode, p.cfg.ReturnHandler)
+	if err != nil {
+		channel.Close()
+		return nil, fmt.Errorf("unable to put rmq channel in confirm mode: %w", err)
 	}
+
+	return pubChannel, nil
 }
 
 // background - producer background tasks
 func (p *Publisher) background() {
 	ticker := time.NewTicker(p.cfg.CleanUpInterval)
-	errChan := p.connection.NotifyClose(make(chan *amqp.Error))
-	var notifyErr *amqp.Error
+	defer ticker.Stop()
+	reconnectChan := p.connection.NotifyReconnect(make(chan struct{}, 1))
+	defer p.connection.StopNotifyReconnect(reconnectChan)
 	for {
 		select {
-		case notifyErr = <-errChan:
-			if notifyErr == nil {
-				continue
+		case <-reconnectChan:
+			// idle channels belong to the lost connection, so drop them
+			logrus.Info("rmq connection is reestablished, dropping stale channels")
+			for _, resource := range p.pool.AcquireAllIdle() {
+				resource.Destroy()
 			}
-
 		case tick := <-ticker.C:
 			logrus.Infof("clean up event at %s", tick)
 			for _, resource := range p.pool.AcquireAllIdle() {
@@ -0,0 +1,300 @@
+package rmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+	"sync"
+)
+
+// ReturnIDHeader - header with private publishing id, stamped on mandatory messages in confirm mode
+// for matching of broker returns with Publish calls
+const ReturnIDHeader = "x-rmq-return-id"
+
+var (
+	// ErrPublishNacked - message was nacked by broker in confirm mode
+	ErrPublishNacked = errors.New("message is nacked by broker")
+	// ErrUnroutable - mandatory message was returned by broker, see UnroutableError for details
+	ErrUnroutable = errors.New("message is unroutable")
+)
+
+type (
+	// publisherChannel - pooled channel with publisher confirms tracking
+	publisherChannel struct {
+		channel *amqp.Channel
+		// confirmMode - channel is in confirm mode
+		confirmMode bool
+		// mu - guards publishing order, nextTag and pending
+		mu sync.Mutex
+		// nextTag - delivery tag of the last publishing in confirm mode
+		nextTag uint64
+		// pending - confirmations, waiting for broker ack/nack
+		pending map[uint64]*PublishConfirmation
+		// mandatory - delivery tags of pending mandatory publishings by ReturnIDHeader value
+		mandatory map[string]uint64
+		// returnHandler - handler for returns, which can't be matched with pending publishings
+		returnHandler func(ret amqp.Return)
+	}
+
+	// UnroutableError - mandatory message was returned by broker
+	UnroutableError struct {
+		ReplyCode            uint16
+		ReplyText            string
+		Exchange, RoutingKey string
+	}
+
+	// PublishConfirmation - future of broker acknowledgement of a single publishing
+	PublishConfirmation struct {
+		done chan struct{}
+		// mu - guards err and callbacks
+		mu        sync.Mutex
+		err       error
+		callbacks []func(err error)
+		// returnID - ReturnIDHeader value of mandatory publishing, used for returns matching
+		returnID string
+		// returned - error for the upcoming ack, set if message was returned by broker
+		returned *UnroutableError
+	}
+)
+
+// newPublisherChannel - wraps channel, puts it in confirm mode if required and starts confirms/returns listener
+func newPublisherChannel(
+	channel *amqp.Channel,
+	confirmMode bool,
+	returnHandler func(ret amqp.Return),
+) (*publisherChannel, error) {
+	pc := &publisherChannel{
+		channel:       channel,
+		confirmMode:   confirmMode,
+		pending:       make(map[uint64]*PublishConfirmation),
+		mandatory:     make(map[string]uint64),
+		returnHandler: returnHandler,
+	}
+
+	var confirms chan amqp.Confirmation
+	if confirmMode {
+		if err := channel.Confirm(false); err != nil {
+			return nil, err
+		}
+
+		confirms = channel.NotifyPublish(make(chan amqp.Confirmation, 16))
+	}
+
+	// returns channel is unbuffered: broker sends basic.return before basic.ack,
+	// so return is received by listener before the ack of the same message
+	go pc.listen(confirms, channel.NotifyReturn(make(chan amqp.Return)))
+
+	return pc, nil
+}
+
+// publish - publishes message, returns confirmation in confirm mode, nil otherwise
+func (pc *publisherChannel) publish(msg *PublishMessage) (*PublishConfirmation, error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	publishing := msg.Publishing
+	// mandatory returns are matched with publishings by private id: MessageId is set by caller
+	// and may be the same for several pending publishings (retries, republished messages)
+	track := pc.confirmMode && msg.Mandatory
+	var returnID string
+	if track {
+		returnID = newID()
+		headers := make(amqp.Table, len(publishing.Headers)+1)
+		for key, value := range publishing.Headers {
+			headers[key] = value
+		}
+
+		headers[ReturnIDHeader] = returnID
+		publishing.Headers = headers
+	}
+
+	err := pc.channel.Publish(msg.ExchangeName, msg.RoutingKey, msg.Mandatory, msg.Immediate, publishing)
+	if err != nil || !pc.confirmMode {
+		return nil, err
+	}
+
+	pc.nextTag++
+	c := newPublishConfirmation()
+	pc.pending[pc.nextTag] = c
+	if track {
+		c.returnID = returnID
+		pc.mandatory[returnID] = pc.nextTag
+	}
+
+	return c, nil
+}
+
+// listen - resolves pending confirmations by delivery tag and matches returns with them,
+// fails all pending after channel close
+func (pc *publisherChannel) listen(confirms chan amqp.Confirmation, returns chan amqp.Return) {
+	for confirms != nil || returns != nil {
+		select {
+		case confirm, ok := <-confirms:
+			if !ok {
+				confirms = nil
+				continue
+			}
+
+			var err error
+			if !confirm.Ack {
+				err = ErrPublishNacked
+			}
+
+			pc.resolve(confirm.DeliveryTag, err)
+		case ret, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+
+			pc.handleReturn(ret)
+		}
+	}
+
+	pc.mu.Lock()
+	pending := pc.pending
+	pc.pending = make(map[uint64]*PublishConfirmation)
+	pc.mandatory = make(map[string]uint64)
+	pc.mu.Unlock()
+
+	for _, c := range pending {
+		c.resolve(amqp.ErrClosed)
+	}
+}
+
+// resolve - resolves a single pending confirmation, ack of returned message is resolved with UnroutableError
+func (pc *publisherChannel) resolve(tag uint64, err error) {
+	pc.mu.Lock()
+	c, ok := pc.pending[tag]
+	delete(pc.pending, tag)
+	if ok && c.returnID != "" {
+		delete(pc.mandatory, c.returnID)
+	}
+
+	if ok && err == nil && c.returned != nil {
+		err = c.returned
+	}
+	pc.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	c.resolve(err)
+}
+
+// handleReturn - marks pending publishing as returned or passes return to returnHandler
+func (pc *publisherChannel) handleReturn(ret amqp.Return) {
+	pc.mu.Lock()
+	returnID, _ := ret.Headers[ReturnIDHeader].(string)
+	tag, ok := pc.mandatory[returnID]
+	var c *PublishConfirmation
+	if ok {
+		c, ok = pc.pending[tag]
+	}
+
+	if ok {
+		c.returned = &UnroutableError{
+			ReplyCode:  ret.ReplyCode,
+			ReplyText:  ret.ReplyText,
+			Exchange:   ret.Exchange,
+			RoutingKey: ret.RoutingKey,
+		}
+	}
+	pc.mu.Unlock()
+
+	if ok {
+		return
+	}
+
+	if pc.returnHandler != nil {
+		pc.returnHandler(ret)
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"exchange":    ret.Exchange,
+		"routing_key": ret.RoutingKey,
+		"reply_code":  ret.ReplyCode,
+		"reply_text":  ret.ReplyText,
+	}).Warning("unroutable message was returned by broker")
+}
+
+// Error - error interface implementation
+func (ue *UnroutableError) Error() string {
+	return fmt.Sprintf("%s: %d %s", ErrUnroutable, ue.ReplyCode, ue.ReplyText)
+}
+
+// Is - errors.Is support, UnroutableError matches ErrUnroutable
+func (ue *UnroutableError) Is(target error) bool {
+	return target == ErrUnroutable
+}
+
+// newPublishConfirmation - creates unresolved confirmation
+func newPublishConfirmation() *PublishConfirmation {
+	return &PublishConfirmation{done: make(chan struct{})}
+}
+
+// Done - closed when broker acks or nacks the message
+func (c *PublishConfirmation) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err - result of confirmation: nil on ack, ErrPublishNacked on nack, amqp.ErrClosed if channel was closed.
+// Is valid only after Done
+func (c *PublishConfirmation) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.err
+}
+
+// Wait - blocks until broker ack/nack or ctx is done
+func (c *PublishConfirmation) Wait(ctx context.Context) error {
+	select {
+	case <-c.done:
+		return c.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// OnDone - registers callback, which is called once after ack/nack in a separate goroutine.
+// If confirmation is already resolved, callback is called immediately in the caller goroutine
+func (c *PublishConfirmation) OnDone(callback func(err error)) {
+	c.mu.Lock()
+	select {
+	case <-c.done:
+		err := c.err
+		c.mu.Unlock()
+		callback(err)
+		return
+	default:
+	}
+
+	c.callbacks = append(c.callbacks, callback)
+	c.mu.Unlock()
+}
+
+// resolve - sets result and runs callbacks in a separate goroutine: resolve is called by confirms listener,
+// which must not be blocked by callbacks (e.g. by Publish call in confirm mode)
+func (c *PublishConfirmation) resolve(err error) {
+	c.mu.Lock()
+	c.err = err
+	close(c.done)
+	callbacks := c.callbacks
+	c.callbacks = nil
+	c.mu.Unlock()
+
+	if len(callbacks) == 0 {
+		return
+	}
+
+	go func() {
+		for _, callback := range callbacks {
+			callback(err)
+		}
+	}()
+}
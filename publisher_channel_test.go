@@ -0,0 +1,119 @@
+package rmq
+
+import (
+	"context"
+	"errors"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"testing"
+)
+
+func Test_publisherChannel_listen(t *testing.T) {
+	pc := &publisherChannel{confirmMode: true, pending: make(map[uint64]*PublishConfirmation)}
+	confirmations := make([]*PublishConfirmation, 3)
+	for i := range confirmations {
+		confirmations[i] = newPublishConfirmation()
+		pc.pending[uint64(i+1)] = confirmations[i]
+	}
+
+	confirms := make(chan amqp.Confirmation, 2)
+	confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: true}
+	confirms <- amqp.Confirmation{DeliveryTag: 2, Ack: false}
+	close(confirms)
+	returns := make(chan amqp.Return)
+	close(returns)
+	pc.listen(confirms, returns)
+
+	wantErrs := []error{nil, ErrPublishNacked, amqp.ErrClosed}
+	for i, c := range confirmations {
+		if err := c.Wait(context.Background()); !errors.Is(err, wantErrs[i]) {
+			t.Errorf("confirmation %d err = %v, want %v", i+1, err, wantErrs[i])
+		}
+	}
+
+	if len(pc.pending) != 0 {
+		t.Errorf("pending confirmations left: %d", len(pc.pending))
+	}
+}
+
+func TestPublishConfirmation_WaitCtx(t *testing.T) {
+	c := newPublishConfirmation()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := c.Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait() err = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestPublishConfirmation_OnDone(t *testing.T) {
+	c := newPublishConfirmation()
+	results := make(chan error, 2)
+	// blocked callback doesn't block resolve (confirms listener)
+	release := make(chan struct{})
+	c.OnDone(func(err error) {
+		<-release
+		results <- err
+	})
+	c.resolve(ErrPublishNacked)
+
+	// callback after resolve is called immediately
+	c.OnDone(func(err error) { results <- err })
+	if err := <-results; err != ErrPublishNacked {
+		t.Errorf("callback result = %v, want %v", err, ErrPublishNacked)
+	}
+
+	close(release)
+	if err := <-results; err != ErrPublishNacked {
+		t.Errorf("callback result = %v, want %v", err, ErrPublishNacked)
+	}
+}
+
+func Test_publisherChannel_handleReturn(t *testing.T) {
+	var unmatched []amqp.Return
+	pc := &publisherChannel{
+		confirmMode: true,
+		pending:     make(map[uint64]*PublishConfirmation),
+		mandatory:   make(map[string]uint64),
+		returnHandler: func(ret amqp.Return) {
+			unmatched = append(unmatched, ret)
+		},
+	}
+
+	c := newPublishConfirmation()
+	c.returnID = "return-1"
+	pc.pending[1] = c
+	pc.mandatory[c.returnID] = 1
+
+	// the same MessageId of different publishings doesn't affect matching
+	pc.handleReturn(amqp.Return{
+		MessageId: "msg-1",
+		Headers:   amqp.Table{ReturnIDHeader: "return-1"},
+		ReplyCode: 312,
+		ReplyText: "NO_ROUTE",
+	})
+	pc.handleReturn(amqp.Return{
+		MessageId: "msg-1",
+		Headers:   amqp.Table{ReturnIDHeader: "return-2"},
+		ReplyCode: 312,
+		ReplyText: "NO_ROUTE",
+	})
+	pc.resolve(1, nil)
+
+	err := c.Wait(context.Background())
+	if !errors.Is(err, ErrUnroutable) {
+		t.Fatalf("Wait() err = %v, want %v", err, ErrUnroutable)
+	}
+
+	var unroutable *UnroutableError
+	if !errors.As(err, &unroutable) || unroutable.ReplyCode != 312 || unroutable.ReplyText != "NO_ROUTE" {
+		t.Errorf("unexpected unroutable error details: %v", err)
+	}
+
+	if len(unmatched) != 1 || unmatched[0].Headers[ReturnIDHeader] != "return-2" {
+		t.Errorf("unmatched returns = %v, want only return-2", unmatched)
+	}
+
+	if len(pc.mandatory) != 0 {
+		t.Errorf("mandatory index is not cleaned up")
+	}
+}
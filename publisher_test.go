@@ -0,0 +1,244 @@
+package rmq
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/puddle"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"testing"
+	"time"
+)
+
+func TestPublisher_withRetry(t *testing.T) {
+	errFatal := errors.New("fatal")
+	policy := &PublishRetryPolicy{
+		Backoff:   &ConstantBackoff{Interval: time.Millisecond, MaxAttempts: 3},
+		Retryable: IsRetryablePublishErr,
+	}
+
+	tests := []struct {
+		name      string
+		retry     *PublishRetryPolicy
+		errs      []error
+		cancel    bool
+		notReady  bool
+		wantCalls int
+		wantErr   error
+	}{
+		{
+			name:      "success",
+			retry:     policy,
+			wantCalls: 1,
+		},
+		{
+			name:      "retries disabled",
+			errs:      []error{amqp.ErrClosed},
+			wantCalls: 1,
+			wantErr:   amqp.ErrClosed,
+		},
+		{
+			name:      "retryable error is retried",
+			retry:     policy,
+			errs:      []error{amqp.ErrClosed, ErrConnectionNotReady},
+			wantCalls: 3,
+		},
+		{
+			name:      "not retryable error",
+			retry:     policy,
+			errs:      []error{errFatal},
+			wantCalls: 1,
+			wantErr:   errFatal,
+		},
+		{
+			name:      "attempts limit",
+			retry:     policy,
+			errs:      []error{amqp.ErrClosed, amqp.ErrClosed, amqp.ErrClosed, amqp.ErrClosed},
+			wantCalls: 3,
+			wantErr:   amqp.ErrClosed,
+		},
+		{
+			name:      "ctx cancel",
+			retry:     policy,
+			errs:      []error{amqp.ErrClosed, amqp.ErrClosed},
+			cancel:    true,
+			wantCalls: 1,
+			wantErr:   amqp.ErrClosed,
+		},
+		{
+			name:      "connection is not recovered",
+			retry:     policy,
+			errs:      []error{amqp.ErrClosed, amqp.ErrClosed},
+			notReady:  true,
+			wantCalls: 1,
+			wantErr:   amqp.ErrClosed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+			defer cancel()
+
+			connection := NewConnection(context.Background(), nil)
+			if !tt.notReady {
+				connection.markReady()
+			}
+
+			p := &Publisher{connection: connection, cfg: PublisherConfig{Retry: tt.retry}}
+			calls := 0
+			err := p.withRetry(ctx, func() error {
+				calls++
+				if tt.cancel {
+					cancel()
+				}
+
+				if calls > len(tt.errs) {
+					return nil
+				}
+
+				return tt.errs[calls-1]
+			})
+
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Errorf("withRetry() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if calls != tt.wantCalls {
+				t.Errorf("publish calls = %d, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func newTestPublisher() *Publisher {
+	p := NewPublisher(NewConnection(context.Background(), nil), &PublisherConfig{})
+	p.pool = puddle.NewPool(
+		func(context.Context) (interface{}, error) { return &publisherChannel{}, nil },
+		func(interface{}) {},
+		1,
+	)
+
+	return p
+}
+
+func TestPublisher_PublishAfterShutdown(t *testing.T) {
+	p := newTestPublisher()
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	if err := p.Publish(context.Background(), &PublishMessage{}); !errors.Is(err, ErrPublisherClosed) {
+		t.Errorf("Publish() error = %v, want %v", err, ErrPublisherClosed)
+	}
+
+	if _, err := p.PublishAsync(context.Background(), &PublishMessage{}); !errors.Is(err, ErrPublisherClosed) {
+		t.Errorf("PublishAsync() error = %v, want %v", err, ErrPublisherClosed)
+	}
+}
+
+func TestPublisher_ShutdownWaitsConfirmation(t *testing.T) {
+	p := newTestPublisher()
+
+	// unresolved PublishAsync confirmation
+	if !p.startPublish() {
+		t.Fatalf("startPublish() on running publisher must succeed")
+	}
+	confirm := newPublishConfirmation()
+	confirm.OnDone(func(error) {
+		p.inFlight.Done()
+	})
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- p.Shutdown(context.Background())
+	}()
+
+	select {
+	case err := <-stopped:
+		t.Fatalf("Shutdown() returned before confirmation, error = %v", err)
+	case <-time.After(time.Millisecond * 20):
+	}
+
+	confirm.resolve(nil)
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Errorf("Shutdown() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Shutdown() is not finished after confirmation")
+	}
+}
+
+func TestPublisher_ShutdownTimeout(t *testing.T) {
+	p := newTestPublisher()
+	if !p.startPublish() {
+		t.Fatalf("startPublish() on running publisher must succeed")
+	}
+	defer p.inFlight.Done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+
+	if err := p.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestPublisher_chanInit(t *testing.T) {
+	connection := NewConnectionWithCfg(context.Background(), nil, &ConnectionCfg{
+		Backoff: &ConstantBackoff{Interval: time.Millisecond, MaxAttempts: 3},
+	})
+	connection.markReady()
+	p := NewPublisher(connection, &PublisherConfig{})
+
+	// connection without amqp.Connection can't open channels
+	if _, err := p.chanInit(context.Background()); !errors.Is(err, amqp.ErrClosed) {
+		t.Errorf("chanInit() error = %v, want %v", err, amqp.ErrClosed)
+	}
+
+	connection = NewConnectionWithCfg(context.Background(), nil, &ConnectionCfg{Backoff: &ConstantBackoff{Interval: time.Second}})
+	p = NewPublisher(connection, &PublisherConfig{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+
+	if _, err := p.chanInit(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("chanInit() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestPublisher_spoolNacked(t *testing.T) {
+	spool, err := OpenDiskSpool(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("OpenDiskSpool() error = %v", err)
+	}
+	defer spool.Close()
+
+	p := NewPublisher(NewConnection(context.Background(), nil), &PublisherConfig{Spool: spool})
+	tests := []struct {
+		name      string
+		result    error
+		wantErr   error
+		wantSpool int
+	}{
+		{name: "ack", wantSpool: 0},
+		{name: "nack is spooled", result: ErrPublishNacked, wantSpool: 1},
+		{name: "unroutable", result: &UnroutableError{ReplyCode: 312}, wantErr: ErrUnroutable, wantSpool: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			confirm := newPublishConfirmation()
+			result := p.spoolNacked(spoolTestMessage("nacked"), confirm)
+			confirm.resolve(tt.result)
+
+			if err := result.Wait(context.Background()); !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Errorf("confirmation error = %v, want %v", err, tt.wantErr)
+			}
+
+			if spool.Len() != tt.wantSpool {
+				t.Errorf("spool len = %d, want %d", spool.Len(), tt.wantSpool)
+			}
+		})
+	}
+}
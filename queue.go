@@ -14,7 +14,8 @@ type (
 	}
 	// QueueManager - queue manager
 	QueueManager struct {
-		channel *amqp.Channel
+		channel  *amqp.Channel
+		recorder *schemaRecorder
 	}
 )
 
@@ -70,8 +71,11 @@ func (qs *QueueManager) Delete(params *DeleteParams) (msgCount int, err error) {
 	msgCount, err = qs.channel.QueueDelete(params.Name, params.IfUnused, params.IfEmpty, params.NoWait)
 	if err != nil {
 		err = fmt.Errorf("queue delete error: %w", err)
+		return
 	}
 
+	qs.recorder.queueDeleted(*params)
+
 	return
 }
 
@@ -111,8 +115,11 @@ func (qs *QueueManager) Declare(declareParams *DeclareParams) (q amqp.Queue, err
 
 	if err != nil {
 		err = fmt.Errorf("queue declare err: %w", err)
+		return
 	}
 
+	qs.recorder.queueDeclared(*declareParams)
+
 	return
 }
 
@@ -145,8 +152,11 @@ func (qs *QueueManager) Bind(bindParams *QueueBindParams) (err error) {
 
 	if err != nil {
 		err = fmt.Errorf("queue bind error: %w", err)
+		return
 	}
 
+	qs.recorder.queueBound(*bindParams)
+
 	return
 }
 
@@ -178,8 +188,11 @@ func (qs *QueueManager) Unbind(bindParams *QueueBindParams) (err error) {
 
 	if err != nil {
 		err = fmt.Errorf("queue unbind error: %w", err)
+		return
 	}
 
+	qs.recorder.queueUnbound(*bindParams)
+
 	return
 }
 
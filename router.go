@@ -0,0 +1,182 @@
+package rmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// ErrNoRoute - message doesn't match any route and router has no fallback handler
+var ErrNoRoute = errors.New("no route for message")
+
+type (
+	// Router - MessageHandler, which dispatches messages by routing key, topic pattern, headers or Type.
+	// Routes are checked in registration order, the first matched handler is called.
+	// Routes must be registered before consuming starts
+	Router struct {
+		routes   []route
+		fallback MessageHandler
+	}
+
+	// route - single router rule
+	route struct {
+		match   func(msg *amqp.Delivery) bool
+		handler MessageHandler
+	}
+)
+
+// NewRouter - Router constructor
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Route - routes messages with exact routing key
+func (r *Router) Route(routingKey string, handler MessageHandler) *Router {
+	return r.add(func(msg *amqp.Delivery) bool {
+		return msg.RoutingKey == routingKey
+	}, handler)
+}
+
+// Topic - routes messages by AMQP topic pattern: * matches a single word, # matches zero or more words
+func (r *Router) Topic(pattern string, handler MessageHandler) *Router {
+	words := strings.Split(pattern, ".")
+	return r.add(func(msg *amqp.Delivery) bool {
+		return matchTopic(words, strings.Split(msg.RoutingKey, "."))
+	}, handler)
+}
+
+// Headers - routes messages by headers like headers exchange: all headers must match if matchAll is set,
+// any of them otherwise. Numbers are compared by value, so int route value matches int32 header of delivery
+func (r *Router) Headers(headers amqp.Table, matchAll bool, handler MessageHandler) *Router {
+	return r.add(func(msg *amqp.Delivery) bool {
+		for key, value := range headers {
+			actual, ok := msg.Headers[key]
+			matched := ok && headerValuesEqual(actual, value)
+			if matched != matchAll {
+				return matched
+			}
+		}
+
+		return matchAll
+	}, handler)
+}
+
+// Type - routes messages by Type property
+func (r *Router) Type(msgType string, handler MessageHandler) *Router {
+	return r.add(func(msg *amqp.Delivery) bool {
+		return msg.Type == msgType
+	}, handler)
+}
+
+// Fallback - sets handler for unmatched messages, they are rejected with ErrNoRoute if fallback is not set
+func (r *Router) Fallback(handler MessageHandler) *Router {
+	r.fallback = handler
+	return r
+}
+
+// Handle - MessageHandler implementation
+func (r *Router) Handle(ctx context.Context, channel *amqp.Channel, msg *amqp.Delivery) error {
+	for _, rt := range r.routes {
+		if rt.match(msg) {
+			return rt.handler.Handle(ctx, channel, msg)
+		}
+	}
+
+	if r.fallback != nil {
+		return r.fallback.Handle(ctx, channel, msg)
+	}
+
+	if err := doMsgAction(msg, ActionReject); err != nil {
+		return fmt.Errorf("reject error: %s, prev err: %w", err, ErrNoRoute)
+	}
+
+	return fmt.Errorf("%w: %s", ErrNoRoute, msg.RoutingKey)
+}
+
+// add - appends route
+func (r *Router) add(match func(msg *amqp.Delivery) bool, handler MessageHandler) *Router {
+	r.routes = append(r.routes, route{match: match, handler: handler})
+	return r
+}
+
+// matchTopic - AMQP topic matching of routing key words with pattern words
+func matchTopic(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+
+	switch pattern[0] {
+	case "#":
+		// # consumes zero or more words
+		for i := 0; i <= len(words); i++ {
+			if matchTopic(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+
+		return false
+	case "*":
+		return len(words) > 0 && matchTopic(pattern[1:], words[1:])
+	default:
+		return len(words) > 0 && pattern[0] == words[0] && matchTopic(pattern[1:], words[1:])
+	}
+}
+
+// headerValuesEqual - compares header values, numbers of different types are compared by value,
+// because amqp091 encodes and decodes them with different Go types (int is received as int32)
+func headerValuesEqual(a, b interface{}) bool {
+	a, b = normalizeNumber(a), normalizeNumber(b)
+	switch av := a.(type) {
+	case int64:
+		if bv, ok := b.(float64); ok {
+			return float64(av) == bv
+		}
+	case float64:
+		if bv, ok := b.(int64); ok {
+			return av == float64(bv)
+		}
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+// normalizeNumber - converts integers to int64 (or uint64 if value doesn't fit) and floats to float64
+func normalizeNumber(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint:
+		return normalizeUnsigned(uint64(v))
+	case uint8:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case uint64:
+		return normalizeUnsigned(v)
+	case float32:
+		return float64(v)
+	default:
+		return value
+	}
+}
+
+// normalizeUnsigned - uint64 as int64 if it fits
+func normalizeUnsigned(v uint64) interface{} {
+	if v > math.MaxInt64 {
+		return v
+	}
+
+	return int64(v)
+}
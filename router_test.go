@@ -0,0 +1,133 @@
+package rmq
+
+import (
+	"context"
+	"errors"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"strings"
+	"testing"
+)
+
+func Test_matchTopic(t *testing.T) {
+	tests := []struct {
+		pattern, routingKey string
+		want                bool
+	}{
+		{"orders.created", "orders.created", true},
+		{"orders.created", "orders.updated", false},
+		{"orders.*", "orders.created", true},
+		{"orders.*", "orders.created.eu", false},
+		{"orders.*", "orders", false},
+		{"orders.#", "orders", true},
+		{"orders.#", "orders.created.eu", true},
+		{"#.eu", "orders.created.eu", true},
+		{"#.eu", "eu", true},
+		{"*.created.#", "orders.created", true},
+		{"*.created.#", "created", false},
+		{"#", "", true},
+		{"#", "anything.at.all", true},
+		{"orders.#.eu", "orders.eu", true},
+		{"orders.#.eu", "orders.created.us", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.routingKey, func(t *testing.T) {
+			got := matchTopic(strings.Split(tt.pattern, "."), strings.Split(tt.routingKey, "."))
+			if got != tt.want {
+				t.Errorf("matchTopic() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_Router_Handle(t *testing.T) {
+	var called string
+	handler := func(name string) MessageHandler {
+		return MessageHandlerFunc(func(context.Context, *amqp.Channel, *amqp.Delivery) error {
+			called = name
+			return nil
+		})
+	}
+
+	router := NewRouter().
+		Route("orders.created", handler("exact")).
+		Topic("orders.#", handler("topic")).
+		Headers(amqp.Table{"tenant": "acme", "region": "eu"}, true, handler("all headers")).
+		Headers(amqp.Table{"tenant": "acme", "priority": int64(1)}, false, handler("any header")).
+		Type("invoice", handler("type"))
+
+	tests := []struct {
+		name string
+		msg  amqp.Delivery
+		want string
+	}{
+		{"Exact key", amqp.Delivery{RoutingKey: "orders.created"}, "exact"},
+		{"Topic", amqp.Delivery{RoutingKey: "orders.updated"}, "topic"},
+		{"All headers", amqp.Delivery{Headers: amqp.Table{"tenant": "acme", "region": "eu"}}, "all headers"},
+		{"Any header", amqp.Delivery{Headers: amqp.Table{"priority": int64(1)}}, "any header"},
+		{"Type", amqp.Delivery{Type: "invoice"}, "type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called = ""
+			if err := router.Handle(context.Background(), nil, &tt.msg); err != nil || called != tt.want {
+				t.Errorf("Handle() = %v, called %q, want %q", err, called, tt.want)
+			}
+		})
+	}
+
+	ack := &testAcknowledger{}
+	err := router.Handle(context.Background(), nil, &amqp.Delivery{Acknowledger: ack, RoutingKey: "users.created"})
+	if !errors.Is(err, ErrNoRoute) || ack.rejects != 1 {
+		t.Errorf("Handle() of unmatched message = %v, rejects = %d, want %v and reject", err, ack.rejects, ErrNoRoute)
+	}
+
+	router.Fallback(handler("fallback"))
+	_ = router.Handle(context.Background(), nil, &amqp.Delivery{RoutingKey: "users.created"})
+	if called != "fallback" {
+		t.Errorf("called %q, want fallback", called)
+	}
+}
+
+func Test_headerValuesEqual(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b interface{}
+		want bool
+	}{
+		{"int and int32", 1, int32(1), true},
+		{"int and int64", 1, int64(1), true},
+		{"int64 and uint8", int64(7), uint8(7), true},
+		{"int and float64", 2, float64(2), true},
+		{"float32 and float64", float32(0.5), 0.5, true},
+		{"Different numbers", int32(1), int64(2), false},
+		{"Number and string", 1, "1", false},
+		{"Strings", "acme", "acme", true},
+		{"Tables", amqp.Table{"a": "b"}, amqp.Table{"a": "b"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := headerValuesEqual(tt.a, tt.b); got != tt.want {
+				t.Errorf("headerValuesEqual() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_Router_HeadersNumbers(t *testing.T) {
+	called := false
+	router := NewRouter().Headers(amqp.Table{"priority": 1}, true, MessageHandlerFunc(
+		func(context.Context, *amqp.Channel, *amqp.Delivery) error {
+			called = true
+			return nil
+		},
+	))
+
+	// amqp091 decodes int header as int32
+	for _, value := range []interface{}{int32(1), int64(1)} {
+		called = false
+		_ = router.Handle(context.Background(), nil, &amqp.Delivery{Headers: amqp.Table{"priority": value}})
+		if !called {
+			t.Errorf("route with int header doesn't match %T delivery header", value)
+		}
+	}
+}
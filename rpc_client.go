@@ -0,0 +1,194 @@
+package rmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+	"sync"
+)
+
+// DirectReplyTo - RabbitMQ pseudo queue for direct reply-to
+const DirectReplyTo = "amq.rabbitmq.reply-to"
+
+// ErrRPCClientClosed - rpc client is closed
+var ErrRPCClientClosed = errors.New("rpc client is closed")
+
+type (
+	// RPCClient - request/response client over direct reply-to. Requests are published on the channel,
+	// which consumes replies, so a single channel is shared by all calls
+	RPCClient struct {
+		connection *Connection
+		ctx        context.Context
+		done       context.CancelFunc
+		// mu - guards channel and pending, serializes publishing
+		mu sync.Mutex
+		// channel - replies channel, nil until the first call or after channel loss
+		channel *amqp.Channel
+		// pending - waiting calls by correlation id
+		pending map[string]chan rpcReply
+	}
+
+	// rpcReply - reply or call error
+	rpcReply struct {
+		delivery *amqp.Delivery
+		err      error
+	}
+)
+
+// NewRPCClient - RPCClient constructor
+func NewRPCClient(connection *Connection) *RPCClient {
+	client := &RPCClient{
+		connection: connection,
+		pending:    make(map[string]chan rpcReply),
+	}
+	client.ctx, client.done = context.WithCancel(connection.ctx)
+
+	return client
+}
+
+// Call - publishes request with ReplyTo and generated CorrelationId and waits for reply until ctx is done.
+// Returns UnroutableError if mandatory request was returned by broker, amqp.ErrClosed if channel was lost
+func (c *RPCClient) Call(ctx context.Context, msg *PublishMessage) (*amqp.Delivery, error) {
+	if c.ctx.Err() != nil {
+		return nil, ErrRPCClientClosed
+	}
+
+	correlationID := newID()
+	reply := make(chan rpcReply, 1)
+
+	publishing := msg.Publishing
+	publishing.ReplyTo = DirectReplyTo
+	publishing.CorrelationId = correlationID
+
+	c.mu.Lock()
+	channel, err := c.replyChannel()
+	if err != nil {
+		c.mu.Unlock()
+		return nil, err
+	}
+
+	c.pending[correlationID] = reply
+	err = channel.Publish(msg.ExchangeName, msg.RoutingKey, msg.Mandatory, msg.Immediate, publishing)
+	c.mu.Unlock()
+
+	// abandoned calls are removed, late replies are dropped by listener
+	defer c.forget(correlationID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case r := <-reply:
+		return r.delivery, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.ctx.Done():
+		return nil, ErrRPCClientClosed
+	}
+}
+
+// Close - closes replies channel, waiting calls return ErrRPCClientClosed
+func (c *RPCClient) Close() error {
+	c.done()
+
+	c.mu.Lock()
+	channel := c.channel
+	c.channel = nil
+	c.mu.Unlock()
+
+	if channel == nil {
+		return nil
+	}
+
+	return channel.Close()
+}
+
+// replyChannel - opens channel and starts replies consuming if required, must be called under mu
+func (c *RPCClient) replyChannel() (*amqp.Channel, error) {
+	if c.channel != nil {
+		return c.channel, nil
+	}
+
+	channel, err := c.connection.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	// direct reply-to requires no-ack consuming
+	deliveries, err := channel.Consume(DirectReplyTo, "", true, false, false, false, nil)
+	if err != nil {
+		channel.Close()
+		return nil, fmt.Errorf("reply-to consume error: %w", err)
+	}
+
+	go c.listen(channel, deliveries, channel.NotifyReturn(make(chan amqp.Return, 1)))
+
+	c.channel = channel
+	return channel, nil
+}
+
+// listen - passes replies and returns to waiting calls, fails all pending calls after channel close
+func (c *RPCClient) listen(channel *amqp.Channel, deliveries <-chan amqp.Delivery, returns chan amqp.Return) {
+	for deliveries != nil || returns != nil {
+		select {
+		case delivery, ok := <-deliveries:
+			if !ok {
+				deliveries = nil
+				continue
+			}
+
+			c.resolve(delivery.CorrelationId, rpcReply{delivery: &delivery})
+		case ret, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+
+			c.resolve(ret.CorrelationId, rpcReply{err: &UnroutableError{
+				ReplyCode:  ret.ReplyCode,
+				ReplyText:  ret.ReplyText,
+				Exchange:   ret.Exchange,
+				RoutingKey: ret.RoutingKey,
+			}})
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.channel != channel {
+		return
+	}
+
+	c.channel = nil
+	for correlationID, reply := range c.pending {
+		reply <- rpcReply{err: amqp.ErrClosed}
+		delete(c.pending, correlationID)
+	}
+}
+
+// resolve - sends reply to the waiting call
+func (c *RPCClient) resolve(correlationID string, r rpcReply) {
+	c.mu.Lock()
+	reply, ok := c.pending[correlationID]
+	delete(c.pending, correlationID)
+	c.mu.Unlock()
+
+	if !ok {
+		logrus.WithField("correlation_id", correlationID).Warning("reply for unknown or abandoned call is dropped")
+		return
+	}
+
+	reply <- r
+}
+
+// forget - removes call from pending
+func (c *RPCClient) forget(correlationID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.pending, correlationID)
+}
@@ -0,0 +1,39 @@
+package rmq
+
+import (
+	"context"
+	"errors"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"testing"
+)
+
+func Test_RPCClient_listen(t *testing.T) {
+	client := NewRPCClient(NewConnection(context.Background(), nil))
+	replied, returned, lost := make(chan rpcReply, 1), make(chan rpcReply, 1), make(chan rpcReply, 1)
+	client.pending = map[string]chan rpcReply{"replied": replied, "returned": returned, "lost": lost}
+
+	deliveries, returns := make(chan amqp.Delivery, 2), make(chan amqp.Return, 1)
+	deliveries <- amqp.Delivery{CorrelationId: "replied", Body: []byte("reply")}
+	deliveries <- amqp.Delivery{CorrelationId: "abandoned"}
+	returns <- amqp.Return{CorrelationId: "returned", ReplyCode: 312, ReplyText: "NO_ROUTE"}
+	close(deliveries)
+	close(returns)
+
+	client.listen(nil, deliveries, returns)
+
+	if r := <-replied; r.err != nil || string(r.delivery.Body) != "reply" {
+		t.Errorf("replied call = %+v, want reply body", r)
+	}
+
+	if r := <-returned; !errors.Is(r.err, ErrUnroutable) {
+		t.Errorf("returned call err = %v, want %v", r.err, ErrUnroutable)
+	}
+
+	if r := <-lost; !errors.Is(r.err, amqp.ErrClosed) {
+		t.Errorf("lost call err = %v, want %v", r.err, amqp.ErrClosed)
+	}
+
+	if len(client.pending) != 0 {
+		t.Errorf("pending calls = %d, want 0", len(client.pending))
+	}
+}
@@ -0,0 +1,108 @@
+package rmq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// RPCErrorHeader - header of error reply, contains error text
+const RPCErrorHeader = "x-rpc-error"
+
+// ErrNoReplyTo - rpc request has no ReplyTo property
+var ErrNoReplyTo = errors.New("rpc request has no reply-to")
+
+type (
+	// RPCHandleFunc - handles rpc request, returns response body and headers
+	RPCHandleFunc func(ctx context.Context, msg *amqp.Delivery) (body []byte, headers amqp.Table, err error)
+
+	// RPCHandler - MessageHandler for rpc server: publishes response to ReplyTo with the same CorrelationId
+	// and acks request. Handler errors are replied with RPCError, request is acked too
+	RPCHandler struct {
+		// HandleFunc - request handler
+		HandleFunc RPCHandleFunc
+		// ContentType - content type of successful responses
+		ContentType string
+	}
+
+	// RPCError - structured error reply, body of error reply is json encoded RPCError
+	RPCError struct {
+		Message string `json:"error"`
+	}
+)
+
+// NewRPCHandler - RPCHandler constructor
+func NewRPCHandler(handleFunc RPCHandleFunc) *RPCHandler {
+	return &RPCHandler{HandleFunc: handleFunc}
+}
+
+// Handle - MessageHandler implementation. Request without ReplyTo is rejected,
+// request is requeued if response can't be published
+func (rh *RPCHandler) Handle(ctx context.Context, channel *amqp.Channel, msg *amqp.Delivery) error {
+	if msg.ReplyTo == "" {
+		if err := msg.Reject(false); err != nil {
+			return fmt.Errorf("reject error: %s, prev err: %w", err, ErrNoReplyTo)
+		}
+
+		return ErrNoReplyTo
+	}
+
+	body, headers, err := rh.HandleFunc(ctx, msg)
+	response := amqp.Publishing{
+		CorrelationId: msg.CorrelationId,
+		ContentType:   rh.ContentType,
+		Headers:       headers,
+		Body:          body,
+	}
+
+	if err != nil {
+		response = newRPCErrorReply(msg.CorrelationId, err)
+	}
+
+	if pErr := channel.Publish("", msg.ReplyTo, false, false, response); pErr != nil {
+		pErr = fmt.Errorf("rpc reply publish error: %w", pErr)
+		if nErr := msg.Nack(false, true); nErr != nil {
+			return fmt.Errorf("requeue error: %s, prev err: %w", nErr, pErr)
+		}
+
+		return pErr
+	}
+
+	if aErr := msg.Ack(false); aErr != nil {
+		return fmt.Errorf("error while broker notify action: %w", aErr)
+	}
+
+	// handler error is already sent to client, but is returned for logging
+	return err
+}
+
+// Error - error interface implementation
+func (re *RPCError) Error() string {
+	return fmt.Sprintf("rpc error: %s", re.Message)
+}
+
+// RPCReplyError - returns RPCError if reply is an error reply, nil otherwise
+func RPCReplyError(reply *amqp.Delivery) error {
+	value, ok := reply.Headers[RPCErrorHeader]
+	if !ok {
+		return nil
+	}
+
+	return &RPCError{Message: fmt.Sprint(value)}
+}
+
+// newRPCErrorReply - error reply with RPCErrorHeader and json body
+func newRPCErrorReply(correlationID string, err error) amqp.Publishing {
+	rpcErr := RPCError{Message: err.Error()}
+	// struct with a single string field is always encoded
+	body, _ := json.Marshal(&rpcErr)
+
+	return amqp.Publishing{
+		CorrelationId: correlationID,
+		ContentType:   "application/json",
+		Headers:       amqp.Table{RPCErrorHeader: rpcErr.Message},
+		Body:          body,
+	}
+}
@@ -0,0 +1,38 @@
+package rmq
+
+import (
+	"context"
+	"errors"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"testing"
+)
+
+func Test_RPCHandler_NoReplyTo(t *testing.T) {
+	ack := &testAcknowledger{}
+	handler := NewRPCHandler(func(context.Context, *amqp.Delivery) ([]byte, amqp.Table, error) {
+		t.Fatalf("HandleFunc must not be called without reply-to")
+		return nil, nil, nil
+	})
+
+	err := handler.Handle(context.Background(), nil, &amqp.Delivery{Acknowledger: ack})
+	if !errors.Is(err, ErrNoReplyTo) || ack.rejects != 1 {
+		t.Errorf("Handle() error = %v, rejects = %d, want %v and 1 reject", err, ack.rejects, ErrNoReplyTo)
+	}
+}
+
+func Test_newRPCErrorReply(t *testing.T) {
+	reply := newRPCErrorReply("id", errors.New("failed"))
+	if reply.CorrelationId != "id" || string(reply.Body) != `{"error":"failed"}` {
+		t.Errorf("newRPCErrorReply() = %+v", reply)
+	}
+
+	err := RPCReplyError(&amqp.Delivery{Headers: reply.Headers})
+	var rpcErr *RPCError
+	if !errors.As(err, &rpcErr) || rpcErr.Message != "failed" {
+		t.Errorf("RPCReplyError() = %v, want rpc error: failed", err)
+	}
+
+	if err = RPCReplyError(&amqp.Delivery{}); err != nil {
+		t.Errorf("RPCReplyError() of success reply = %v, want nil", err)
+	}
+}
@@ -41,6 +41,8 @@ type (
 		Queue    *QueueManager
 		Exchange *ExchangeManager
 		channel  *amqp.Channel
+		// recorder - records successful operations for topology recovery, nil if recovery is disabled
+		recorder *schemaRecorder
 	}
 	// Preset - interface for apply any set of params to schema
 	Preset interface {
@@ -49,11 +51,21 @@ type (
 )
 
 // ApplyPresets - apply presets to schema
+// preset is recorded for topology recovery as a whole, nested operations are not recorded
 func (sc *Schema) ApplyPresets(presets ...Preset) (err error) {
 	for _, preset := range presets {
-		if err = preset.Apply(sc.channel, sc); err != nil {
+		sc.recorder.pause()
+		err = preset.Apply(sc.channel, sc)
+		sc.recorder.resume()
+
+		if err != nil {
 			return
 		}
+
+		preset := preset
+		sc.recorder.record(func(schema *Schema) error {
+			return schema.ApplyPresets(preset)
+		})
 	}
 
 	return
@@ -88,9 +100,19 @@ func (dp *DeclareParams) WithDeadLetterRk(key string) *DeclareParams {
 
 // GetSchema - creates a new Schema instance
 func GetSchema(channel *amqp.Channel) *Schema {
-	return &Schema{
+	return newSchema(channel, nil)
+}
+
+// newSchema - creates a new Schema instance with recorder shared between managers
+func newSchema(channel *amqp.Channel, recorder *schemaRecorder) *Schema {
+	schema := &Schema{
 		Queue:    NewQueueManager(channel),
 		Exchange: NewExchangeManager(channel),
 		channel:  channel,
+		recorder: recorder,
 	}
+	schema.Queue.recorder = recorder
+	schema.Exchange.recorder = recorder
+
+	return schema
 }
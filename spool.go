@@ -0,0 +1,662 @@
+package rmq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/jackc/puddle"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// spoolSegmentExt - extension of segment files
+	spoolSegmentExt = ".seg"
+	// spoolCheckpointFile - file with position of the oldest not replayed record
+	spoolCheckpointFile = "checkpoint"
+	// spoolHeaderSize - record header: payload length and crc32 of payload
+	spoolHeaderSize = 8
+	// spoolMaxPayload - protection from broken length in header, equals to broker max message size
+	spoolMaxPayload = 512 << 20
+)
+
+// ErrSpoolCorrupted - spool record checksum mismatch or decode error, such records are skipped on replay
+var ErrSpoolCorrupted = errors.New("spool record is corrupted")
+
+func init() {
+	// types, which may be stored in amqp.Table headers
+	gob.Register(amqp.Table{})
+	gob.Register([]interface{}{})
+	gob.Register(time.Time{})
+	gob.Register(amqp.Decimal{})
+}
+
+type (
+	// DiskSpool - write-ahead spool of unpublished messages in append-only segment files.
+	// Spooled messages survive process restart and are replayed by Publisher, see PublisherConfig.Spool
+	DiskSpool struct {
+		dir string
+		cfg DiskSpoolCfg
+		// mu - guards all fields below
+		mu sync.Mutex
+		// active - segment for appends
+		active     *os.File
+		activeID   uint64
+		activeSize int64
+		// reader - segment with the oldest record
+		reader     *os.File
+		readID     uint64
+		readOffset int64
+		// count, size - not replayed records count and summary size
+		count int
+		size  int64
+		// notify - wakes up replay on new records
+		notify chan struct{}
+	}
+
+	// DiskSpoolCfg - DiskSpool settings
+	DiskSpoolCfg struct {
+		// SegmentSize - max size of a single segment file, default is 16Mb
+		SegmentSize int64
+		// SyncWrites - fsync every append and replay checkpoint, slower but safe on power loss
+		SyncWrites bool
+	}
+
+	// SpoolEntry - spooled message
+	SpoolEntry struct {
+		Message   PublishMessage
+		SpooledAt time.Time
+	}
+
+	// SpoolStats - spool inspection data
+	SpoolStats struct {
+		// Messages - not replayed messages count
+		Messages int
+		// Bytes - summary size of not replayed records on disk
+		Bytes int64
+		// Oldest - spool time of the oldest message, zero if spool is empty
+		Oldest time.Time
+	}
+)
+
+// OpenDiskSpool - opens or creates spool in dir, cfg may be nil
+func OpenDiskSpool(dir string, cfg *DiskSpoolCfg) (*DiskSpool, error) {
+	spool := &DiskSpool{dir: dir, notify: make(chan struct{}, 1)}
+	if cfg != nil {
+		spool.cfg = *cfg
+	}
+
+	if spool.cfg.SegmentSize == 0 {
+		spool.cfg.SegmentSize = 16 << 20
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("spool dir create error: %w", err)
+	}
+
+	if err := spool.load(); err != nil {
+		spool.Close()
+		return nil, err
+	}
+
+	return spool, nil
+}
+
+// Append - writes message to the end of spool
+func (ds *DiskSpool) Append(msg *PublishMessage) error {
+	record, err := encodeSpoolRecord(&SpoolEntry{Message: *msg, SpooledAt: time.Now()})
+	if err != nil {
+		return err
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if ds.activeSize > 0 && ds.activeSize+int64(len(record)) > ds.cfg.SegmentSize {
+		if err = ds.rotate(); err != nil {
+			return err
+		}
+	}
+
+	if _, err = ds.active.Write(record); err != nil {
+		return fmt.Errorf("spool write error: %w", err)
+	}
+
+	if ds.cfg.SyncWrites {
+		if err = ds.active.Sync(); err != nil {
+			return fmt.Errorf("spool sync error: %w", err)
+		}
+	}
+
+	ds.activeSize += int64(len(record))
+	ds.count++
+	ds.size += int64(len(record))
+
+	select {
+	case ds.notify <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Peek - the oldest not replayed entry, nil if spool is empty
+func (ds *DiskSpool) Peek() (*SpoolEntry, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	entry, _, err := ds.peek()
+	return entry, err
+}
+
+// Commit - marks the oldest entry as replayed
+func (ds *DiskSpool) Commit() error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	_, recordSize, err := ds.peek()
+	if err != nil || recordSize == 0 {
+		return err
+	}
+
+	ds.readOffset += recordSize
+	ds.count--
+	ds.size -= recordSize
+
+	if err = ds.skipConsumed(); err != nil {
+		return err
+	}
+
+	return ds.writeCheckpoint()
+}
+
+// Len - not replayed messages count
+func (ds *DiskSpool) Len() int {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	return ds.count
+}
+
+// Stats - spool size and the oldest message time
+func (ds *DiskSpool) Stats() (SpoolStats, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	stats := SpoolStats{Messages: ds.count, Bytes: ds.size}
+	entry, _, err := ds.peek()
+	if entry != nil {
+		stats.Oldest = entry.SpooledAt
+	}
+
+	return stats, err
+}
+
+// Close - closes segment files
+func (ds *DiskSpool) Close() error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	var err error
+	for _, file := range []*os.File{ds.active, ds.reader} {
+		if file == nil {
+			continue
+		}
+
+		if cErr := file.Close(); cErr != nil {
+			err = cErr
+		}
+	}
+
+	ds.active, ds.reader = nil, nil
+	return err
+}
+
+// load - restores state from checkpoint and segment files, truncates broken tail after crash
+func (ds *DiskSpool) load() error {
+	ids, err := ds.segments()
+	if err != nil {
+		return err
+	}
+
+	ds.readID, ds.readOffset, err = ds.readCheckpoint()
+	if err != nil {
+		return err
+	}
+
+	// segments before checkpoint are fully replayed
+	for len(ids) > 0 && ids[0] < ds.readID {
+		if err = os.Remove(ds.segmentPath(ids[0])); err != nil {
+			return fmt.Errorf("spool segment remove error: %w", err)
+		}
+		ids = ids[1:]
+	}
+
+	if len(ids) == 0 {
+		if ds.readID == 0 {
+			ds.readID = 1
+		}
+		ds.readOffset = 0
+		ids = []uint64{ds.readID}
+	}
+
+	if ids[0] > ds.readID {
+		ds.readID, ds.readOffset = ids[0], 0
+	}
+
+	for num, id := range ids {
+		offset := int64(0)
+		if id == ds.readID {
+			offset = ds.readOffset
+		}
+
+		end, count, err := scanSpoolSegment(ds.segmentPath(id), offset)
+		if err != nil {
+			return err
+		}
+
+		// drops broken tail record, if it exists
+		if num < len(ids)-1 {
+			if err = os.Truncate(ds.segmentPath(id), end); err != nil {
+				return fmt.Errorf("spool segment truncate error: %w", err)
+			}
+		}
+
+		ds.count += count
+		ds.size += end - offset
+
+		if num == len(ids)-1 {
+			ds.activeID, ds.activeSize = id, end
+		}
+	}
+
+	ds.active, err = os.OpenFile(ds.segmentPath(ds.activeID), os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("spool segment open error: %w", err)
+	}
+
+	// drops broken tail record, if it exists
+	if err = ds.active.Truncate(ds.activeSize); err != nil {
+		return fmt.Errorf("spool segment truncate error: %w", err)
+	}
+
+	if _, err = ds.active.Seek(ds.activeSize, io.SeekStart); err != nil {
+		return fmt.Errorf("spool segment seek error: %w", err)
+	}
+
+	return nil
+}
+
+// peek - reads record at read position, must be called under mu
+func (ds *DiskSpool) peek() (*SpoolEntry, int64, error) {
+	for ds.count > 0 {
+		if err := ds.skipConsumed(); err != nil {
+			return nil, 0, err
+		}
+
+		if ds.reader == nil {
+			reader, err := os.Open(ds.segmentPath(ds.readID))
+			if err != nil {
+				return nil, 0, fmt.Errorf("spool segment open error: %w", err)
+			}
+			ds.reader = reader
+		}
+
+		entry, recordSize, err := readSpoolRecord(ds.reader, ds.readOffset)
+		if err == nil {
+			return entry, recordSize, nil
+		}
+
+		// broken record would block replay forever, so it is skipped, other read errors may be temporary
+		if !errors.Is(err, ErrSpoolCorrupted) && !errors.Is(err, io.EOF) {
+			return nil, 0, err
+		}
+
+		logrus.WithError(err).Errorf("spool record at %d:%d is skipped", ds.readID, ds.readOffset)
+		if err = ds.skipRecord(recordSize); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return nil, 0, nil
+}
+
+// skipRecord - moves read position over broken record. If record size is unknown (broken header or
+// truncated payload), the rest of segment is skipped and counters are restored from disk. Must be called under mu
+func (ds *DiskSpool) skipRecord(recordSize int64) error {
+	if recordSize > 0 {
+		ds.readOffset += recordSize
+		ds.count--
+		ds.size -= recordSize
+		return ds.writeCheckpoint()
+	}
+
+	end := ds.activeSize
+	if ds.readID < ds.activeID {
+		info, err := os.Stat(ds.segmentPath(ds.readID))
+		if err != nil {
+			return fmt.Errorf("spool segment stat error: %w", err)
+		}
+		end = info.Size()
+	}
+
+	ds.readOffset = end
+	if err := ds.recount(); err != nil {
+		return err
+	}
+
+	return ds.writeCheckpoint()
+}
+
+// recount - restores records count and size from read position, must be called under mu
+func (ds *DiskSpool) recount() error {
+	ids, err := ds.segments()
+	if err != nil {
+		return err
+	}
+
+	ds.count, ds.size = 0, 0
+	for _, id := range ids {
+		if id < ds.readID {
+			continue
+		}
+
+		offset := int64(0)
+		if id == ds.readID {
+			offset = ds.readOffset
+		}
+
+		end, count, err := scanSpoolSegment(ds.segmentPath(id), offset)
+		if err != nil {
+			return err
+		}
+
+		ds.count += count
+		ds.size += end - offset
+	}
+
+	return nil
+}
+
+// skipConsumed - removes fully replayed segments before active one, must be called under mu
+func (ds *DiskSpool) skipConsumed() error {
+	for ds.readID < ds.activeID {
+		info, err := os.Stat(ds.segmentPath(ds.readID))
+		if err != nil {
+			return fmt.Errorf("spool segment stat error: %w", err)
+		}
+
+		if ds.readOffset < info.Size() {
+			return nil
+		}
+
+		if ds.reader != nil {
+			ds.reader.Close()
+			ds.reader = nil
+		}
+
+		if err = os.Remove(ds.segmentPath(ds.readID)); err != nil {
+			return fmt.Errorf("spool segment remove error: %w", err)
+		}
+
+		ds.readID++
+		ds.readOffset = 0
+	}
+
+	return nil
+}
+
+// rotate - starts a new active segment, must be called under mu
+func (ds *DiskSpool) rotate() error {
+	if err := ds.active.Close(); err != nil {
+		return fmt.Errorf("spool segment close error: %w", err)
+	}
+
+	active, err := os.OpenFile(ds.segmentPath(ds.activeID+1), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("spool segment create error: %w", err)
+	}
+
+	ds.active = active
+	ds.activeID++
+	ds.activeSize = 0
+
+	return nil
+}
+
+// segments - sorted ids of existing segment files
+func (ds *DiskSpool) segments() ([]uint64, error) {
+	files, err := os.ReadDir(ds.dir)
+	if err != nil {
+		return nil, fmt.Errorf("spool dir read error: %w", err)
+	}
+
+	ids := make([]uint64, 0, len(files))
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, spoolSegmentExt) {
+			continue
+		}
+
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, spoolSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// segmentPath - path of segment file by id
+func (ds *DiskSpool) segmentPath(id uint64) string {
+	return filepath.Join(ds.dir, fmt.Sprintf("%020d%s", id, spoolSegmentExt))
+}
+
+// readCheckpoint - read position, zero values if checkpoint doesn't exist
+func (ds *DiskSpool) readCheckpoint() (id uint64, offset int64, err error) {
+	data, err := os.ReadFile(filepath.Join(ds.dir, spoolCheckpointFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, 0, nil
+	}
+
+	if err != nil {
+		return 0, 0, fmt.Errorf("spool checkpoint read error: %w", err)
+	}
+
+	if len(data) != 16 {
+		return 0, 0, fmt.Errorf("spool checkpoint has wrong size: %d", len(data))
+	}
+
+	return binary.BigEndian.Uint64(data[:8]), int64(binary.BigEndian.Uint64(data[8:])), nil
+}
+
+// writeCheckpoint - atomically saves read position, must be called under mu
+func (ds *DiskSpool) writeCheckpoint() error {
+	data := make([]byte, 16)
+	binary.BigEndian.PutUint64(data[:8], ds.readID)
+	binary.BigEndian.PutUint64(data[8:], uint64(ds.readOffset))
+
+	path := filepath.Join(ds.dir, spoolCheckpointFile)
+	tmp, err := os.Create(path + ".tmp")
+	if err != nil {
+		return fmt.Errorf("spool checkpoint write error: %w", err)
+	}
+
+	if _, err = tmp.Write(data); err == nil && ds.cfg.SyncWrites {
+		err = tmp.Sync()
+	}
+
+	if cErr := tmp.Close(); err == nil {
+		err = cErr
+	}
+
+	if err != nil {
+		return fmt.Errorf("spool checkpoint write error: %w", err)
+	}
+
+	if err = os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("spool checkpoint write error: %w", err)
+	}
+
+	return nil
+}
+
+// encodeSpoolRecord - header (payload length, crc32) and gob encoded entry
+func encodeSpoolRecord(entry *SpoolEntry) ([]byte, error) {
+	var payload bytes.Buffer
+	if err := gob.NewEncoder(&payload).Encode(entry); err != nil {
+		return nil, fmt.Errorf("spool record encode error: %w", err)
+	}
+
+	record := make([]byte, spoolHeaderSize, spoolHeaderSize+payload.Len())
+	binary.BigEndian.PutUint32(record[:4], uint32(payload.Len()))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload.Bytes()))
+
+	return append(record, payload.Bytes()...), nil
+}
+
+// readSpoolRecord - reads and decodes record at offset, returns record size.
+// Record size is returned with ErrSpoolCorrupted too, if header of record is valid
+func readSpoolRecord(reader io.ReaderAt, offset int64) (*SpoolEntry, int64, error) {
+	header := make([]byte, spoolHeaderSize)
+	if _, err := reader.ReadAt(header, offset); err != nil {
+		return nil, 0, fmt.Errorf("spool record read error: %w", err)
+	}
+
+	length := binary.BigEndian.Uint32(header[:4])
+	if length > spoolMaxPayload {
+		return nil, 0, ErrSpoolCorrupted
+	}
+
+	payload := make([]byte, length)
+	if _, err := reader.ReadAt(payload, offset+spoolHeaderSize); err != nil {
+		return nil, 0, fmt.Errorf("spool record read error: %w", err)
+	}
+
+	recordSize := int64(spoolHeaderSize + len(payload))
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, recordSize, ErrSpoolCorrupted
+	}
+
+	entry := &SpoolEntry{}
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(entry); err != nil {
+		return nil, recordSize, fmt.Errorf("%w: decode error: %s", ErrSpoolCorrupted, err)
+	}
+
+	return entry, recordSize, nil
+}
+
+// scanSpoolSegment - counts valid records from offset, returns end of the last valid record
+func scanSpoolSegment(path string, offset int64) (end int64, count int, err error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, 0, nil
+	}
+
+	if err != nil {
+		return 0, 0, fmt.Errorf("spool segment open error: %w", err)
+	}
+	defer file.Close()
+
+	end = offset
+	for {
+		_, recordSize, rErr := readSpoolRecord(file, end)
+		// corrupted record with valid header is counted and skipped on replay
+		if rErr != nil && recordSize > 0 {
+			logrus.WithError(rErr).Warningf("spool segment %s has corrupted record at %d", path, end)
+		} else if rErr != nil {
+			if !errors.Is(rErr, io.EOF) {
+				logrus.WithError(rErr).Warningf("spool segment %s has broken tail at %d", path, end)
+			}
+
+			return end, count, nil
+		}
+
+		end += recordSize
+		count++
+	}
+}
+
+// shouldSpool - message goes to spool while connection is down or spool is not empty (keeps order)
+func (p *Publisher) shouldSpool() bool {
+	return p.cfg.Spool != nil && (p.connection.IsClosed() || p.cfg.Spool.Len() > 0)
+}
+
+// spoolMessage - writes message to spool
+func (p *Publisher) spoolMessage(msg *PublishMessage) error {
+	if err := p.cfg.Spool.Append(msg); err != nil {
+		return fmt.Errorf("unable to spool message: %w", err)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"exchange":    msg.ExchangeName,
+		"routing_key": msg.RoutingKey,
+	}).Warning("message is spooled")
+
+	return nil
+}
+
+// replaySpool - background task, publishes spooled messages in order when connection is ready
+func (p *Publisher) replaySpool() {
+	spool := p.cfg.Spool
+	for {
+		for spool.Len() > 0 {
+			if err := p.connection.waitReady(p.ctx); err != nil {
+				return
+			}
+
+			// corrupted records are skipped by Peek, so error means i/o failure
+			entry, err := spool.Peek()
+			if err == nil && entry == nil {
+				continue
+			}
+
+			if err == nil {
+				err = p.publishAndWait(p.ctx, &entry.Message)
+			}
+
+			// record is removed only after confirmed publish or if it can never be published
+			if err == nil || errors.Is(err, ErrUnroutable) {
+				if err != nil {
+					logrus.WithError(err).Error("spooled message is not published")
+				}
+
+				if err = spool.Commit(); err != nil {
+					logrus.WithError(err).Error("spool commit error")
+				}
+
+				continue
+			}
+
+			// publisher is stopping, record stays in spool for the next start
+			if p.ctx.Err() != nil || errors.Is(err, puddle.ErrClosedPool) {
+				return
+			}
+
+			logrus.WithError(err).Warning("unable to replay spool, retry in a second")
+			if sleepCtx(p.ctx, time.Second) != nil {
+				return
+			}
+		}
+
+		select {
+		case <-spool.notify:
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package rmq
+
+import (
+	"encoding/binary"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func spoolTestMessage(body string) *PublishMessage {
+	return &PublishMessage{
+		ExchangeName: "exchange",
+		RoutingKey:   body,
+		Publishing:   amqp.Publishing{Body: []byte(body), Headers: amqp.Table{"key": "value"}},
+	}
+}
+
+func Test_DiskSpool_Replay(t *testing.T) {
+	dir := t.TempDir()
+	spool, err := OpenDiskSpool(dir, &DiskSpoolCfg{SegmentSize: 256})
+	if err != nil {
+		t.Fatalf("OpenDiskSpool() error = %v", err)
+	}
+
+	bodies := []string{"first", "second", "third", "fourth", "fifth"}
+	for _, body := range bodies {
+		if err = spool.Append(spoolTestMessage(body)); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+
+	// consume first two messages and reopen spool
+	for _, want := range bodies[:2] {
+		entry, err := spool.Peek()
+		if err != nil || entry == nil || entry.Message.RoutingKey != want {
+			t.Fatalf("Peek() = %v, %v, want %s", entry, err, want)
+		}
+
+		if err = spool.Commit(); err != nil {
+			t.Fatalf("Commit() error = %v", err)
+		}
+	}
+
+	if err = spool.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	spool, err = OpenDiskSpool(dir, &DiskSpoolCfg{SegmentSize: 256})
+	if err != nil {
+		t.Fatalf("OpenDiskSpool() error = %v", err)
+	}
+	defer spool.Close()
+
+	if spool.Len() != 3 {
+		t.Fatalf("Len() = %d, want 3", spool.Len())
+	}
+
+	for _, want := range bodies[2:] {
+		entry, err := spool.Peek()
+		if err != nil || entry == nil || entry.Message.RoutingKey != want {
+			t.Fatalf("Peek() = %v, %v, want %s", entry, err, want)
+		}
+
+		if entry.Message.Publishing.Headers["key"] != "value" {
+			t.Errorf("headers = %v, want key: value", entry.Message.Publishing.Headers)
+		}
+
+		if err = spool.Commit(); err != nil {
+			t.Fatalf("Commit() error = %v", err)
+		}
+	}
+
+	if entry, err := spool.Peek(); entry != nil || err != nil {
+		t.Errorf("Peek() on empty spool = %v, %v", entry, err)
+	}
+
+	stats, _ := spool.Stats()
+	if stats.Messages != 0 || stats.Bytes != 0 || !stats.Oldest.IsZero() {
+		t.Errorf("Stats() on empty spool = %+v", stats)
+	}
+}
+
+func Test_DiskSpool_BrokenTail(t *testing.T) {
+	dir := t.TempDir()
+	spool, err := OpenDiskSpool(dir, nil)
+	if err != nil {
+		t.Fatalf("OpenDiskSpool() error = %v", err)
+	}
+
+	_ = spool.Append(spoolTestMessage("first"))
+	_ = spool.Append(spoolTestMessage("second"))
+	_ = spool.Close()
+
+	// emulate crash during the last write
+	path := filepath.Join(dir, "00000000000000000001.seg")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("segment stat error = %v", err)
+	}
+
+	if err = os.Truncate(path, info.Size()-3); err != nil {
+		t.Fatalf("segment truncate error = %v", err)
+	}
+
+	spool, err = OpenDiskSpool(dir, nil)
+	if err != nil {
+		t.Fatalf("OpenDiskSpool() error = %v", err)
+	}
+	defer spool.Close()
+
+	if spool.Len() != 1 {
+		t.Fatalf("Len() = %d, want 1", spool.Len())
+	}
+
+	if err = spool.Append(spoolTestMessage("third")); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	for _, want := range []string{"first", "third"} {
+		entry, err := spool.Peek()
+		if err != nil || entry == nil || entry.Message.RoutingKey != want {
+			t.Fatalf("Peek() = %v, %v, want %s", entry, err, want)
+		}
+		_ = spool.Commit()
+	}
+}
+
+func Test_DiskSpool_CorruptedRecord(t *testing.T) {
+	for _, reopen := range []bool{false, true} {
+		dir := t.TempDir()
+		spool, err := OpenDiskSpool(dir, nil)
+		if err != nil {
+			t.Fatalf("OpenDiskSpool() error = %v", err)
+		}
+
+		for _, body := range []string{"first", "second", "third"} {
+			_ = spool.Append(spoolTestMessage(body))
+		}
+
+		corruptSecondRecord(t, filepath.Join(dir, "00000000000000000001.seg"))
+
+		// corrupted record in the middle of segment survives reopen and doesn't block replay
+		if reopen {
+			_ = spool.Close()
+			if spool, err = OpenDiskSpool(dir, nil); err != nil {
+				t.Fatalf("OpenDiskSpool() error = %v", err)
+			}
+		}
+
+		for _, want := range []string{"first", "third"} {
+			entry, err := spool.Peek()
+			if err != nil || entry == nil || entry.Message.RoutingKey != want {
+				t.Fatalf("reopen %v: Peek() = %v, %v, want %s", reopen, entry, err, want)
+			}
+			_ = spool.Commit()
+		}
+
+		if spool.Len() != 0 {
+			t.Errorf("reopen %v: Len() = %d, want 0", reopen, spool.Len())
+		}
+		_ = spool.Close()
+	}
+}
+
+// corruptSecondRecord - flips the last payload byte of the second record in segment
+func corruptSecondRecord(t *testing.T, path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("segment read error = %v", err)
+	}
+
+	firstSize := int(binary.BigEndian.Uint32(data[:4])) + spoolHeaderSize
+	secondSize := int(binary.BigEndian.Uint32(data[firstSize:firstSize+4])) + spoolHeaderSize
+	data[firstSize+secondSize-1] ^= 0xff
+	if err = os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("segment write error = %v", err)
+	}
+}
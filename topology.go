@@ -0,0 +1,338 @@
+package rmq
+
+import (
+	"fmt"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// topologyKind - kind of recorded entity, used for pruning of deleted entities
+type topologyKind int
+
+const (
+	// topologyOther - operation, which is never pruned (presets, deletes and unbinds of not recorded entities)
+	topologyOther topologyKind = iota
+	topologyQueue
+	topologyExchange
+	topologyQueueBind
+	topologyExchangeBind
+)
+
+type (
+	// topologyOp - recorded schema operation, replays on a new schema after reconnect
+	topologyOp func(schema *Schema) error
+
+	// topologyEntry - recorded operation with identity of declared entity
+	topologyEntry struct {
+		kind topologyKind
+		// name - queue or exchange name, binding destination
+		name string
+		// source - binding source exchange
+		source string
+		// binding - routing key and args of binding
+		binding string
+		op      topologyOp
+	}
+
+	// topologyRecorder - connection level storage of successful schema operations
+	topologyRecorder struct {
+		mu      sync.Mutex
+		entries []topologyEntry
+	}
+
+	// schemaRecorder - schema level recorder, can be paused for nested operations (see Schema.ApplyPresets)
+	schemaRecorder struct {
+		topology *topologyRecorder
+		paused   int32
+	}
+)
+
+// record - appends entry to the end of the list, entry with the same identity is replaced in place
+func (tr *topologyRecorder) record(entry topologyEntry) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	if entry.kind != topologyOther {
+		for num := range tr.entries {
+			if tr.entries[num].sameEntity(&entry) {
+				tr.entries[num] = entry
+				return
+			}
+		}
+	}
+
+	tr.entries = append(tr.entries, entry)
+}
+
+// prune - removes matched entries, returns removed entries count
+func (tr *topologyRecorder) prune(match func(entry *topologyEntry) bool) int {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	entries := tr.entries[:0]
+	for num := range tr.entries {
+		if !match(&tr.entries[num]) {
+			entries = append(entries, tr.entries[num])
+		}
+	}
+
+	removed := len(tr.entries) - len(entries)
+	for num := len(entries); num < len(tr.entries); num++ {
+		tr.entries[num] = topologyEntry{}
+	}
+	tr.entries = entries
+
+	return removed
+}
+
+// snapshot - copy of recorded operations
+func (tr *topologyRecorder) snapshot() []topologyOp {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	ops := make([]topologyOp, len(tr.entries))
+	for num, entry := range tr.entries {
+		ops[num] = entry.op
+	}
+
+	return ops
+}
+
+// sameEntity - entries declare the same entity
+func (te *topologyEntry) sameEntity(other *topologyEntry) bool {
+	return te.kind == other.kind && te.name == other.name && te.source == other.source && te.binding == other.binding
+}
+
+// record - records operation, which is never pruned, if recorder exists and not paused
+func (sr *schemaRecorder) record(op topologyOp) {
+	sr.recordEntry(topologyEntry{op: op})
+}
+
+// recordEntry - records entry if recorder exists and not paused
+func (sr *schemaRecorder) recordEntry(entry topologyEntry) {
+	if sr == nil || atomic.LoadInt32(&sr.paused) > 0 {
+		return
+	}
+
+	sr.topology.record(entry)
+}
+
+// prune - removes matched entries if recorder exists and not paused, returns true if any entry was removed
+func (sr *schemaRecorder) prune(match func(entry *topologyEntry) bool) bool {
+	if sr == nil || atomic.LoadInt32(&sr.paused) > 0 {
+		return false
+	}
+
+	return sr.topology.prune(match) > 0
+}
+
+// bindingID - identity of binding by routing key and args
+func bindingID(key string, args amqp.Table) string {
+	return fmt.Sprintf("%s\x00%v", key, args)
+}
+
+// isServerNamed - queue name, generated by broker for declare with empty name
+func isServerNamed(queue string) bool {
+	return strings.HasPrefix(queue, "amq.gen-")
+}
+
+// pause - stops recording until resume call
+func (sr *schemaRecorder) pause() {
+	if sr != nil {
+		atomic.AddInt32(&sr.paused, 1)
+	}
+}
+
+// resume - continues recording after pause call
+func (sr *schemaRecorder) resume() {
+	if sr != nil {
+		atomic.AddInt32(&sr.paused, -1)
+	}
+}
+
+// recoverTopology - replays recorded operations in order on a fresh channel
+func (cn *Connection) recoverTopology() {
+	if cn.topology == nil {
+		return
+	}
+
+	ops := cn.topology.snapshot()
+	if len(ops) == 0 {
+		return
+	}
+
+	channel, err := cn.Channel()
+	if err != nil {
+		logrus.WithError(err).Error("unable to open channel for rmq topology recovery")
+		return
+	}
+
+	schema := GetSchema(channel)
+	for num, op := range ops {
+		if err = op(schema); err != nil {
+			logrus.WithError(err).Errorf("rmq topology recovery operation %d failed", num+1)
+		}
+
+		// failed operation closes the channel, so next operations need a new one
+		if channel.IsClosed() {
+			if channel, err = cn.Channel(); err != nil {
+				logrus.WithError(err).Error("unable to reopen channel for rmq topology recovery")
+				return
+			}
+
+			schema = GetSchema(channel)
+		}
+	}
+
+	if err = channel.Close(); err != nil {
+		logrus.WithError(err).Error("error while rmq topology recovery channel close")
+	}
+
+	logrus.Infof("rmq topology is recovered, %d operations replayed", len(ops))
+}
+
+// queueDeclared - records queue declaration, server-named queues can not be redeclared with the same name
+func (sr *schemaRecorder) queueDeclared(params DeclareParams) {
+	if params.Passive || params.Name == "" {
+		return
+	}
+
+	sr.recordEntry(topologyEntry{
+		kind: topologyQueue,
+		name: params.Name,
+		op: func(schema *Schema) error {
+			_, err := schema.Queue.Declare(&params)
+			return err
+		},
+	})
+}
+
+// queueDeleted - removes recorded queue with its bindings, delete of not recorded queue is recorded itself
+func (sr *schemaRecorder) queueDeleted(params DeleteParams) {
+	if isServerNamed(params.Name) {
+		return
+	}
+
+	pruned := sr.prune(func(entry *topologyEntry) bool {
+		return (entry.kind == topologyQueue || entry.kind == topologyQueueBind) && entry.name == params.Name
+	})
+	if pruned {
+		return
+	}
+
+	sr.record(func(schema *Schema) error {
+		_, err := schema.Queue.Delete(&params)
+		return err
+	})
+}
+
+// queueBound - records queue binding, bindings of server-named queues are skipped:
+// queue gets a new name after reconnect, so binding can't be replayed
+func (sr *schemaRecorder) queueBound(params QueueBindParams) {
+	if isServerNamed(params.Name) {
+		return
+	}
+
+	sr.recordEntry(topologyEntry{
+		kind:    topologyQueueBind,
+		name:    params.Name,
+		source:  params.Exchange,
+		binding: bindingID(params.Key, params.Args),
+		op: func(schema *Schema) error {
+			return schema.Queue.Bind(&params)
+		},
+	})
+}
+
+// queueUnbound - removes recorded queue binding, unbind of not recorded binding is recorded itself
+func (sr *schemaRecorder) queueUnbound(params QueueBindParams) {
+	if isServerNamed(params.Name) {
+		return
+	}
+
+	binding := topologyEntry{
+		kind:    topologyQueueBind,
+		name:    params.Name,
+		source:  params.Exchange,
+		binding: bindingID(params.Key, params.Args),
+	}
+	if sr.prune(binding.sameEntity) {
+		return
+	}
+
+	sr.record(func(schema *Schema) error {
+		return schema.Queue.Unbind(&params)
+	})
+}
+
+// exchangeDeclared - records exchange declaration
+func (sr *schemaRecorder) exchangeDeclared(params DeclareParams) {
+	if params.Passive {
+		return
+	}
+
+	sr.recordEntry(topologyEntry{
+		kind: topologyExchange,
+		name: params.Name,
+		op: func(schema *Schema) error {
+			return schema.Exchange.Declare(&params)
+		},
+	})
+}
+
+// exchangeDeleted - removes recorded exchange with all its bindings,
+// delete of not recorded exchange is recorded itself
+func (sr *schemaRecorder) exchangeDeleted(params DeleteParams) {
+	pruned := sr.prune(func(entry *topologyEntry) bool {
+		switch entry.kind {
+		case topologyExchange:
+			return entry.name == params.Name
+		case topologyExchangeBind:
+			return entry.name == params.Name || entry.source == params.Name
+		case topologyQueueBind:
+			return entry.source == params.Name
+		default:
+			return false
+		}
+	})
+	if pruned {
+		return
+	}
+
+	sr.record(func(schema *Schema) error {
+		return schema.Exchange.Delete(&params)
+	})
+}
+
+// exchangeBound - records exchange binding
+func (sr *schemaRecorder) exchangeBound(params ExchangeBindParams) {
+	sr.recordEntry(topologyEntry{
+		kind:    topologyExchangeBind,
+		name:    params.Destination,
+		source:  params.Source,
+		binding: bindingID(params.Key, params.Args),
+		op: func(schema *Schema) error {
+			return schema.Exchange.Bind(&params)
+		},
+	})
+}
+
+// exchangeUnbound - removes recorded exchange binding, unbind of not recorded binding is recorded itself
+func (sr *schemaRecorder) exchangeUnbound(params ExchangeBindParams) {
+	binding := topologyEntry{
+		kind:    topologyExchangeBind,
+		name:    params.Destination,
+		source:  params.Source,
+		binding: bindingID(params.Key, params.Args),
+	}
+	if sr.prune(binding.sameEntity) {
+		return
+	}
+
+	sr.record(func(schema *Schema) error {
+		return schema.Exchange.Unbind(&params)
+	})
+}
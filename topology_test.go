@@ -0,0 +1,148 @@
+package rmq
+
+import (
+	amqp "github.com/rabbitmq/amqp091-go"
+	"testing"
+)
+
+// countingPreset - preset for tests, which counts applies
+type countingPreset struct {
+	applies int
+}
+
+func (cp *countingPreset) Apply(_ *amqp.Channel, _ *Schema) error {
+	cp.applies++
+	return nil
+}
+
+func TestSchema_ApplyPresetsRecording(t *testing.T) {
+	topology := &topologyRecorder{}
+	schema := newSchema(nil, &schemaRecorder{topology: topology})
+	preset := &countingPreset{}
+
+	if err := schema.ApplyPresets(preset); err != nil {
+		t.Fatalf("ApplyPresets() error = %v", err)
+	}
+
+	ops := topology.snapshot()
+	if len(ops) != 1 {
+		t.Fatalf("recorded %d operations, want 1", len(ops))
+	}
+
+	// replay on schema without recorder must not record anything
+	if err := ops[0](GetSchema(nil)); err != nil {
+		t.Fatalf("replay error = %v", err)
+	}
+
+	if preset.applies != 2 {
+		t.Errorf("preset applied %d times, want 2", preset.applies)
+	}
+
+	if len(topology.snapshot()) != 1 {
+		t.Errorf("replay must not be recorded")
+	}
+}
+
+func TestSchema_FailedOperationIsNotRecorded(t *testing.T) {
+	topology := &topologyRecorder{}
+	schema := newSchema(nil, &schemaRecorder{topology: topology})
+
+	if _, err := schema.Queue.Declare(&DeclareParams{Name: "test"}); err == nil {
+		t.Fatalf("Declare() on empty channel must fail")
+	}
+
+	if err := schema.Exchange.Declare(&DeclareParams{Name: "test", Kind: DirectExchange}); err == nil {
+		t.Fatalf("Declare() on empty channel must fail")
+	}
+
+	if len(topology.snapshot()) != 0 {
+		t.Errorf("failed operations must not be recorded")
+	}
+}
+
+func Test_schemaRecorder_prune(t *testing.T) {
+	tests := []struct {
+		name   string
+		record func(sr *schemaRecorder)
+		want   []topologyKind
+	}{
+		{
+			name: "server-named queue bind is skipped",
+			record: func(sr *schemaRecorder) {
+				sr.queueDeclared(DeclareParams{Name: ""})
+				sr.queueBound(QueueBindParams{Name: "amq.gen-JzTY20BRgKO", Key: "key", Exchange: "ex"})
+				sr.queueUnbound(QueueBindParams{Name: "amq.gen-JzTY20BRgKO", Key: "key", Exchange: "ex"})
+				sr.queueDeleted(DeleteParams{Name: "amq.gen-JzTY20BRgKO"})
+			},
+		},
+		{
+			name: "queue delete removes declare and bindings",
+			record: func(sr *schemaRecorder) {
+				sr.exchangeDeclared(DeclareParams{Name: "ex"})
+				for i := 0; i < 3; i++ {
+					sr.queueDeclared(DeclareParams{Name: "tmp"})
+					sr.queueBound(QueueBindParams{Name: "tmp", Key: "key", Exchange: "ex"})
+					sr.queueDeleted(DeleteParams{Name: "tmp"})
+				}
+			},
+			want: []topologyKind{topologyExchange},
+		},
+		{
+			name: "unbind removes matching bind only",
+			record: func(sr *schemaRecorder) {
+				sr.queueDeclared(DeclareParams{Name: "q"})
+				sr.queueBound(QueueBindParams{Name: "q", Key: "a", Exchange: "ex"})
+				sr.queueBound(QueueBindParams{Name: "q", Key: "b", Exchange: "ex"})
+				sr.queueUnbound(QueueBindParams{Name: "q", Key: "a", Exchange: "ex"})
+			},
+			want: []topologyKind{topologyQueue, topologyQueueBind},
+		},
+		{
+			name: "exchange delete removes its bindings",
+			record: func(sr *schemaRecorder) {
+				sr.exchangeDeclared(DeclareParams{Name: "src"})
+				sr.exchangeDeclared(DeclareParams{Name: "dst"})
+				sr.queueDeclared(DeclareParams{Name: "q"})
+				sr.exchangeBound(ExchangeBindParams{Destination: "dst", Key: "key", Source: "src"})
+				sr.queueBound(QueueBindParams{Name: "q", Key: "key", Exchange: "src"})
+				sr.exchangeDeleted(DeleteParams{Name: "src"})
+			},
+			want: []topologyKind{topologyExchange, topologyQueue},
+		},
+		{
+			name: "redeclare replaces entry",
+			record: func(sr *schemaRecorder) {
+				sr.queueDeclared(DeclareParams{Name: "q"})
+				sr.queueDeclared(DeclareParams{Name: "q", Durable: true})
+				sr.queueBound(QueueBindParams{Name: "q", Key: "key", Exchange: "ex"})
+				sr.queueBound(QueueBindParams{Name: "q", Key: "key", Exchange: "ex"})
+			},
+			want: []topologyKind{topologyQueue, topologyQueueBind},
+		},
+		{
+			name: "delete of not recorded entity is recorded",
+			record: func(sr *schemaRecorder) {
+				sr.queueDeleted(DeleteParams{Name: "q"})
+				sr.exchangeUnbound(ExchangeBindParams{Destination: "dst", Key: "key", Source: "src"})
+			},
+			want: []topologyKind{topologyOther, topologyOther},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			topology := &topologyRecorder{}
+			tt.record(&schemaRecorder{topology: topology})
+
+			if len(topology.entries) != len(tt.want) {
+				t.Fatalf("recorded %d entries, want %d", len(topology.entries), len(tt.want))
+			}
+
+			for num, entry := range topology.entries {
+				if entry.kind != tt.want[num] {
+					t.Errorf("entry %d kind = %d, want %d", num, entry.kind, tt.want[num])
+				}
+			}
+		})
+	}
+}